@@ -0,0 +1,297 @@
+// Package client pings ming-mong servers from Go programs
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultSecret signs pings that carry no key ID
+const DefaultSecret = "ming-mong-server"
+
+// Options configure a ping. The zero value sends an unkeyed ping.
+type Options struct {
+	// KeyID selects a named secret on the server and ClientID the client's
+	// own secret (CLIENT_KEYS); Secret must be set with either. Without
+	// KeyID, Secret is the client secret or the server's MING_MONG_SECRET
+	// and the ping is signed with HMACSignature.
+	KeyID    string
+	ClientID string
+	Secret   string
+	// Whoami asks the server for the client's observed address
+	Whoami bool
+	// Proxies asks the server which proxies forwarded the ping
+	Proxies bool
+	// Nonce adds a random nonce to the signature, so a captured ping
+	// can't be replayed. Servers older than nonce support reject it.
+	Nonce bool
+	// ServerKey seals the ping and its reply with NaCl box, for servers
+	// with ENCRYPTION_KEY behind an untrusted TLS terminator. PrivateKey
+	// is the client's key, a new one is made for each ping when nil.
+	ServerKey  *[32]byte
+	PrivateKey *[32]byte
+	// Insecure skips certificate verification, e.g. for self-signed
+	// certificates
+	Insecure bool
+	// Header is sent with the WebSocket handshake, e.g. a User-Agent
+	Header http.Header
+	// Timeout bounds the whole exchange when ctx has no earlier deadline
+	// (default: 10s)
+	Timeout time.Duration
+	// NetDialContext replaces the TCP dialer, e.g. with a
+	// server.PipeListener to ping an embedded server in memory
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ObservedAddress is the client address as seen by the server
+type ObservedAddress struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`
+	TLS      string `json:"tls,omitempty"`
+}
+
+// ProxyInfo lists the forwarding headers the server saw and the number of
+// proxy hops they indicate
+type ProxyInfo struct {
+	Headers []string `json:"headers"`
+	Hops    int      `json:"hops"`
+	Via     []string `json:"via,omitempty"`
+}
+
+// Result is the outcome of a successful ping
+type Result struct {
+	// RTT runs from sending the ping to receiving the pong, excluding the
+	// connection setup
+	RTT time.Duration
+	// Connect is the time taken to dial and upgrade the connection
+	Connect time.Duration
+	// ServerTime is the server clock when it answered
+	ServerTime time.Time
+	// Status is "ok", "maintenance" or "degraded"; Reasons lists the
+	// failing server components when degraded
+	Status  string
+	Reasons []string
+	// ClockSkew is reported by the server when this host's clock is off;
+	// positive means this host is ahead
+	ClockSkew time.Duration
+	// Observed is set when Options.Whoami was requested
+	Observed *ObservedAddress
+	// Proxy is set when Options.Proxies was requested
+	Proxy *ProxyInfo
+}
+
+// ServerError is an error reply from the server, such as
+// "invalid_signature"
+type ServerError struct {
+	Code string
+}
+
+func (e *ServerError) Error() string {
+	return "server error: " + e.Code
+}
+
+type pingMessage struct {
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+	Timestamp string `json:"timestamp"`
+	Whoami    bool   `json:"whoami,omitempty"`
+	Proxies   bool   `json:"proxies,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+}
+
+type pongMessage struct {
+	Type        string           `json:"type"`
+	Status      string           `json:"status"`
+	Error       string           `json:"error"`
+	ServerTime  string           `json:"server_time"`
+	Observed    *ObservedAddress `json:"observed"`
+	Proxy       *ProxyInfo       `json:"proxy"`
+	ClockSkewMs int64            `json:"clock_skew_ms"`
+	Reasons     []string         `json:"reasons"`
+}
+
+// Signature returns the signature of a ping sent at t with secret
+func Signature(t time.Time, secret string) string {
+	return sign(t.UTC().Format("2006-01-02"), secret, false)
+}
+
+// HMACSignature returns the signature of an unkeyed ping sent at t to a
+// server with MING_MONG_SECRET set to secret
+func HMACSignature(t time.Time, secret string) string {
+	return sign(t.UTC().Format("2006-01-02"), secret, true)
+}
+
+// sign signs data, the date optionally followed by a nonce, with
+// HMAC-SHA256 or the legacy SHA256(data + secret)
+func sign(data, secret string, useHMAC bool) string {
+	if useHMAC {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(data))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	hash := sha256.Sum256([]byte(data + secret))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+// Ping dials the WebSocket endpoint at url (e.g. "wss://host:8443/ws"),
+// sends a signed ping and waits for the pong
+func Ping(ctx context.Context, url string, opts Options) (*Result, error) {
+	if opts.KeyID != "" && opts.Secret == "" {
+		return nil, errors.New("key ID without secret")
+	}
+	if opts.ClientID != "" && (opts.Secret == "" || opts.KeyID != "") {
+		return nil, errors.New("client ID needs a secret and no key ID")
+	}
+	var sealer *sealer
+	if opts.ServerKey != nil {
+		var err error
+		if sealer, err = newSealer(opts); err != nil {
+			return nil, err
+		}
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := websocket.Dialer{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.Insecure},
+		NetDialContext:  opts.NetDialContext,
+	}
+
+	// Naming the key when connecting lets the server admit the ping ahead
+	// of anonymous traffic when it is saturated
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	switch {
+	case opts.KeyID != "":
+		url += separator + "key_id=" + neturl.QueryEscape(opts.KeyID)
+	case opts.ClientID != "":
+		url += separator + "client_id=" + neturl.QueryEscape(opts.ClientID)
+	}
+
+	start := time.Now()
+	conn, _, err := dialer.DialContext(ctx, url, opts.Header)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	connected := time.Now()
+
+	// The context bounds reads and writes as well
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+		conn.SetWriteDeadline(deadline)
+	}
+
+	now := time.Now()
+	var nonce string
+	if opts.Nonce {
+		random := make([]byte, 16)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		nonce = hex.EncodeToString(random)
+	}
+	signed := now.UTC().Format("2006-01-02") + nonce
+	signature := sign(signed, DefaultSecret, false)
+	switch {
+	case opts.KeyID != "":
+		signature = sign(signed, opts.Secret, false)
+	case opts.Secret != "":
+		signature = sign(signed, opts.Secret, true)
+	}
+	ping := pingMessage{
+		Type:      "ping",
+		Signature: signature,
+		Nonce:     nonce,
+		Timestamp: now.UTC().Format(time.RFC3339Nano),
+		Whoami:    opts.Whoami,
+		Proxies:   opts.Proxies,
+		KeyID:     opts.KeyID,
+		ClientID:  opts.ClientID,
+	}
+	message, err := json.Marshal(ping)
+	if err == nil && sealer != nil {
+		message, err = sealer.seal(message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	received := time.Now()
+	if sealer != nil {
+		if data, err = sealer.open(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var pong pongMessage
+	if err := json.Unmarshal(data, &pong); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	if pong.Type == "error" {
+		return nil, &ServerError{Code: pong.Error}
+	}
+	if pong.Type != "pong" {
+		return nil, fmt.Errorf("unexpected reply type %q", pong.Type)
+	}
+
+	result := &Result{
+		RTT:       received.Sub(now),
+		Connect:   connected.Sub(start),
+		Status:    pong.Status,
+		Reasons:   pong.Reasons,
+		ClockSkew: time.Duration(pong.ClockSkewMs) * time.Millisecond,
+		Observed:  pong.Observed,
+		Proxy:     pong.Proxy,
+	}
+	if serverTime, err := time.Parse(time.RFC3339Nano, pong.ServerTime); err == nil {
+		result.ServerTime = serverTime
+	}
+	return result, nil
+}
+
+// contextError prefers the context's error over the one caused by closing
+// the connection when the context ended
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
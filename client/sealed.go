@@ -0,0 +1,95 @@
+package client
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// sealedMessage is a message encrypted with NaCl box, see
+// Options.ServerKey
+type sealedMessage struct {
+	Type  string `json:"type"`
+	Key   string `json:"key,omitempty"`
+	Nonce string `json:"nonce"`
+	Box   string `json:"box"`
+}
+
+// ParseKey reads a base64 Curve25519 key, such as the server public key
+// printed by `ming-mong keygen` or logged on startup
+func ParseKey(value string) (*[32]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("invalid key, expected 32 base64-encoded bytes")
+	}
+	var key [32]byte
+	copy(key[:], decoded)
+	return &key, nil
+}
+
+// sealer encrypts a ping to the server and opens the reply
+type sealer struct {
+	serverKey  *[32]byte
+	publicKey  *[32]byte
+	privateKey *[32]byte
+}
+
+// newSealer uses the client key in opts, or a new one for this ping
+func newSealer(opts Options) (*sealer, error) {
+	if opts.PrivateKey == nil {
+		publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &sealer{serverKey: opts.ServerKey, publicKey: publicKey, privateKey: privateKey}, nil
+	}
+
+	privateKey, err := ecdh.X25519().NewPrivateKey(opts.PrivateKey[:])
+	if err != nil {
+		return nil, err
+	}
+	s := &sealer{serverKey: opts.ServerKey, publicKey: new([32]byte), privateKey: opts.PrivateKey}
+	copy(s.publicKey[:], privateKey.PublicKey().Bytes())
+	return s, nil
+}
+
+func (s *sealer) seal(message []byte) ([]byte, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealedMessage{
+		Type:  "sealed",
+		Key:   base64.StdEncoding.EncodeToString(s.publicKey[:]),
+		Nonce: base64.StdEncoding.EncodeToString(nonce[:]),
+		Box:   base64.StdEncoding.EncodeToString(box.Seal(nil, message, &nonce, s.serverKey, s.privateKey)),
+	})
+}
+
+// open returns the plaintext of a sealed reply; other replies, such as
+// errors from before the server could open the ping, are returned as is
+func (s *sealer) open(data []byte) ([]byte, error) {
+	var sealed sealedMessage
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Type != "sealed" {
+		return data, nil
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != 24 {
+		return nil, errors.New("invalid sealed reply: bad nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Box)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed reply: %w", err)
+	}
+	plaintext, ok := box.Open(nil, ciphertext, (*[24]byte)(nonce), s.serverKey, s.privateKey)
+	if !ok {
+		return nil, errors.New("invalid sealed reply: authentication failed")
+	}
+	return plaintext, nil
+}
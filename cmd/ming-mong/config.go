@@ -0,0 +1,382 @@
+package main
+
+import (
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"ming-mong/server"
+)
+
+// configFromEnv maps the environment variables documented in the README to
+// a server configuration. TLS and the endpoints depending on it are set up
+// by main.
+func configFromEnv(port string) server.Config {
+	config := server.DefaultConfig()
+	config.Addr = ":" + port
+	config.CertReloadInterval = envDuration("TLS_RELOAD_INTERVAL", config.CertReloadInterval)
+	config.HandshakeTimeout = envDuration("HANDSHAKE_TIMEOUT", config.HandshakeTimeout)
+	config.IdleTimeout = envDuration("IDLE_TIMEOUT", config.IdleTimeout)
+	config.DrainTimeout = envDuration("DRAIN_TIMEOUT", config.DrainTimeout)
+
+	// Certificates from Let's Encrypt instead of certificate files
+	if value := getenv("ACME_DOMAIN"); value != "" {
+		for _, domain := range strings.Split(value, ",") {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				config.ACMEDomains = append(config.ACMEDomains, domain)
+			}
+		}
+	}
+	config.ACMEEmail = getenv("ACME_EMAIL")
+	if value := getenv("ACME_CACHE_DIR"); value != "" {
+		config.ACMECacheDir = value
+	}
+	config.ACMEDirectoryURL = getenv("ACME_DIRECTORY_URL")
+
+	// TCP keepalive and linger for accepted connections
+	config.TCP.KeepAlive = envBool("TCP_KEEPALIVE", config.TCP.KeepAlive)
+	config.TCP.KeepIdle = envDuration("TCP_KEEPIDLE", config.TCP.KeepIdle)
+	config.TCP.KeepInterval = envDuration("TCP_KEEPINTVL", config.TCP.KeepInterval)
+	config.TCP.KeepCount = envNonNegativeInt("TCP_KEEPCNT", config.TCP.KeepCount)
+	if value := getenv("TCP_LINGER"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			config.TCP.Linger = n
+		} else {
+			invalidSetting("Invalid TCP_LINGER", "value", value)
+		}
+	}
+
+	// Accepted signature days relative to today (UTC)
+	if value := getenv("SIGNATURE_DAY_OFFSETS"); value != "" {
+		offsets, err := server.ParseDayOffsets(value)
+		if err != nil {
+			invalidSetting("Invalid SIGNATURE_DAY_OFFSETS", "error", err)
+		}
+		config.SignatureDayOffsets = offsets
+	}
+
+	// Shortcut for also accepting tomorrow's signature
+	if envBool("ACCEPT_FUTURE_SIGNATURES", false) {
+		config.SignatureDayOffsets = withDayOffset(config.SignatureDayOffsets, 1)
+	}
+
+	// Named signing secrets, optionally replacing the built-in one
+	if value := getenv("SIGNING_KEYS"); value != "" {
+		keys, err := server.ParseSigningKeys(value)
+		if err != nil {
+			invalidSetting("Invalid SIGNING_KEYS", "error", err)
+		}
+		config.SigningKeys = keys
+	}
+
+	// Per-client secrets, revoked by removing them and reloading
+	clientKeys := make(map[string]string)
+	if value := getenv("CLIENT_KEYS"); value != "" {
+		keys, err := server.ParseSigningKeys(value)
+		if err != nil {
+			invalidSetting("Invalid CLIENT_KEYS", "error", err)
+		}
+		clientKeys = keys
+	}
+	if path := getenv("CLIENT_KEYS_FILE"); path != "" {
+		keys, err := server.ParseClientKeysFile(path)
+		if err != nil {
+			invalidSetting("Invalid CLIENT_KEYS_FILE", "error", err)
+		}
+		for clientID, secret := range keys {
+			if _, exists := clientKeys[clientID]; exists {
+				invalidSetting("Client ID in both CLIENT_KEYS and CLIENT_KEYS_FILE", "client_id", clientID)
+			}
+			clientKeys[clientID] = secret
+		}
+	}
+	if len(clientKeys) > 0 {
+		config.ClientKeys = clientKeys
+	}
+
+	config.UnkeyedSignatures = envBool("UNKEYED_SIGNATURES", true)
+	config.Secret = getenv("MING_MONG_SECRET")
+	config.NonceRequired = envBool("NONCE_REQUIRED", false)
+	if windows, err := server.ParseAccessWindows(getenv("ACCESS_WINDOWS")); err != nil {
+		invalidSetting("Invalid ACCESS_WINDOWS", "error", err)
+	} else if len(windows) > 0 {
+		config.AccessWindows = windows
+	}
+	if config.UnkeyedSignatures && config.Secret == "" {
+		slog.Warn("Pings without key_id are signed with the public built-in secret and can be forged; set MING_MONG_SECRET")
+	}
+	if !config.UnkeyedSignatures && len(config.SigningKeys) == 0 && len(config.ClientKeys) == 0 {
+		invalidSetting("UNKEYED_SIGNATURES=false requires SIGNING_KEYS or CLIENT_KEYS")
+	}
+
+	// Sealed messages for deployments behind untrusted TLS terminators
+	if value := getenv("ENCRYPTION_KEY"); value != "" {
+		key, err := server.ParseEncryptionKey(value)
+		if err != nil {
+			invalidSetting("Invalid ENCRYPTION_KEY", "error", err)
+		}
+		config.EncryptionKey = key
+	}
+	if value := getenv("ENCRYPTION_CLIENT_KEYS"); value != "" {
+		keys, err := server.ParseEncryptionClientKeys(value)
+		if err != nil {
+			invalidSetting("Invalid ENCRYPTION_CLIENT_KEYS", "error", err)
+		}
+		config.EncryptionClientKeys = keys
+	}
+	config.EncryptionRequired = envBool("ENCRYPTION_REQUIRED", false)
+	if config.EncryptionRequired && config.EncryptionKey == nil {
+		invalidSetting("ENCRYPTION_REQUIRED=true requires ENCRYPTION_KEY")
+	}
+
+	// Warn clients whose clock is further off than this
+	config.ClockSkewThreshold = envDuration("CLOCK_SKEW_THRESHOLD", config.ClockSkewThreshold)
+
+	// Largest accepted WebSocket message
+	config.MaxMessageSize = int64(envInt("MAX_MESSAGE_SIZE", int(config.MaxMessageSize)))
+
+	// Upgrader buffers are allocated per connection, so smaller ones save
+	// memory at high connection counts
+	config.ReadBufferSize = envInt("WS_READ_BUFFER_SIZE", config.ReadBufferSize)
+	config.WriteBufferSize = envInt("WS_WRITE_BUFFER_SIZE", config.WriteBufferSize)
+	config.WSCompression = envBool("WS_COMPRESSION", config.WSCompression)
+
+	// Long-lived connections with WebSocket control pings
+	config.KeepAliveInterval = envDuration("WS_KEEPALIVE_INTERVAL", 0)
+	config.KeepAliveTimeout = envDuration("WS_KEEPALIVE_TIMEOUT", config.KeepAliveTimeout)
+
+	// Concurrent requests once saturated, with a share held back from
+	// anonymous traffic for registered clients and persistent sessions
+	config.MaxConnections = envNonNegativeInt("MAX_CONNECTIONS", 0)
+	if value := getenv("ANONYMOUS_SHARE"); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f >= 0 && f <= 1 {
+			config.AnonymousShare = f
+		} else {
+			invalidSetting("Invalid ANONYMOUS_SHARE", "value", value)
+		}
+	}
+
+	// Optional frame-size probing over /ws
+	config.Probe = envBool("PROBE_MODE", false)
+	config.ProbeMaxSize = envInt("PROBE_MAX_SIZE", config.ProbeMaxSize)
+
+	// Per-IP connection statistics
+	config.StatsMaxIPs = envInt("STATS_MAX_IPS", config.StatsMaxIPs)
+	config.MaxTags = envInt("TAG_MAX", config.MaxTags)
+	config.HeatmapHours = envInt("HEATMAP_HOURS", config.HeatmapHours)
+	config.HeatmapMaxClients = envInt("HEATMAP_MAX_CLIENTS", config.HeatmapMaxClients)
+
+	// Maintenance mode: forced, toggled by a flag file, or scheduled
+	config.MaintenanceMode = envBool("MAINTENANCE_MODE", false)
+	config.MaintenanceFile = getenv("MAINTENANCE_FILE")
+	if windows, err := server.ParseMaintenanceWindows(getenv("MAINTENANCE_WINDOWS")); err != nil {
+		invalidSetting("Invalid MAINTENANCE_WINDOWS", "error", err)
+	} else {
+		config.MaintenanceWindows = windows
+	}
+	config.DegradedStatus = envBool("DEGRADED_STATUS", false)
+
+	// Endpoints
+	config.WebSocket = envBool("WS_ENDPOINT", true)
+	config.HTTPPing = envBool("HTTP_PING", false)
+	// The landing page is for accepting self-signed certificates, which
+	// ACME certificates don't need
+	config.Landing = envBool("LANDING_PAGE", len(config.ACMEDomains) == 0)
+	config.LandingTemplate = getenv("LANDING_TEMPLATE")
+	if value := getenv("LANDING_PUSH"); value != "" {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				config.LandingPush = append(config.LandingPush, path)
+			}
+		}
+	}
+	config.WellKnownDir = getenv("WELL_KNOWN_DIR")
+	config.StaticDir = getenv("STATIC_DIR")
+	config.AdminToken = getenv("ADMIN_TOKEN")
+	if value := getenv("ADMIN_TOKENS"); value != "" {
+		tokens, err := server.ParseAdminTokens(value)
+		if err != nil {
+			invalidSetting("Invalid ADMIN_TOKENS", "error", err)
+		}
+		config.AdminTokens = tokens
+	}
+	config.Metrics = envBool("METRICS", false)
+	config.MetricsToken = getenv("METRICS_TOKEN")
+	config.HealthChecks = envBool("HEALTH_ENDPOINTS", false)
+	if value := getenv("HEALTH_ALLOW"); value != "" {
+		networks, err := server.ParseHealthAllow(value)
+		if err != nil {
+			invalidSetting("Invalid HEALTH_ALLOW", "error", err)
+		}
+		config.HealthAllow = networks
+	}
+	if value := getenv("ALLOW_CIDRS"); value != "" {
+		networks, err := server.ParseNetworks(value)
+		if err != nil {
+			invalidSetting("Invalid ALLOW_CIDRS", "error", err)
+		}
+		config.AllowCIDRs = networks
+	}
+	if value := getenv("DENY_CIDRS"); value != "" {
+		networks, err := server.ParseNetworks(value)
+		if err != nil {
+			invalidSetting("Invalid DENY_CIDRS", "error", err)
+		}
+		config.DenyCIDRs = networks
+	}
+	if value := getenv("TRUSTED_PROXIES"); value != "" {
+		networks, err := server.ParseNetworks(value)
+		if err != nil {
+			invalidSetting("Invalid TRUSTED_PROXIES", "error", err)
+		}
+		config.TrustedProxies = networks
+	}
+	config.Whoami = envBool("WHOAMI_ENDPOINT", false)
+	config.TimeService = envBool("TIME_SERVICE", false)
+	config.EventsFeed = envBool("EVENTS_FEED", false)
+	config.EventsFile = getenv("EVENTS_FILE")
+	config.ALPNPing = envBool("ALPN_PING", false)
+	if port := getenv("TCP_PING_PORT"); port != "" {
+		if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+			invalidSetting("Invalid TCP_PING_PORT", "value", port)
+		}
+		config.TCPPingAddr = ":" + port
+	}
+	config.Conformance = envBool("CONFORMANCE_ENDPOINT", false)
+	config.APISpec = envBool("API_SPEC", false)
+	config.ClientJS = envBool("CLIENT_JS", false)
+
+	// Negotiated gzip/deflate for HTML and asset responses
+	config.Compression = envBool("COMPRESSION", true)
+
+	// Stealth drops for wrong methods unless upstream WAFs need a 405
+	config.MethodNotAllowed = envBool("METHOD_NOT_ALLOWED", false)
+
+	// Optional public address and reachability self-report
+	config.SelfCheck = envBool("SELF_CHECK", false)
+	if value := getenv("SELF_CHECK_IPV4_URL"); value != "" {
+		config.SelfCheckIPv4URL = value
+	}
+	if value := getenv("SELF_CHECK_IPV6_URL"); value != "" {
+		config.SelfCheckIPv6URL = value
+	}
+
+	// Optional ClickHouse analytics sink
+	config.ClickHouseURL = getenv("CLICKHOUSE_URL")
+	if value := getenv("CLICKHOUSE_TABLE"); value != "" {
+		config.ClickHouseTable = value
+	}
+	config.ClickHouseBatchSize = envInt("CLICKHOUSE_BATCH_SIZE", config.ClickHouseBatchSize)
+	config.ClickHouseFlushInterval = envDuration("CLICKHOUSE_FLUSH_INTERVAL", config.ClickHouseFlushInterval)
+	config.ClickHouseProxy = envProxy("CLICKHOUSE_PROXY")
+
+	// Alert on sudden spikes of invalid signatures
+	config.AuthAnomalyDetection = envBool("AUTH_ANOMALY_DETECTION", false)
+	config.AuthAnomalyInterval = envDuration("AUTH_ANOMALY_INTERVAL", config.AuthAnomalyInterval)
+	if value := getenv("AUTH_ANOMALY_FACTOR"); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 1 {
+			config.AuthAnomalyFactor = f
+		} else {
+			invalidSetting("Invalid AUTH_ANOMALY_FACTOR", "value", value)
+		}
+	}
+	config.AuthAnomalyMin = uint64(envInt("AUTH_ANOMALY_MIN", int(config.AuthAnomalyMin)))
+
+	// Service level objectives with burn-rate alerts
+	if value := getenv("SLOS"); value != "" {
+		slos, err := server.ParseSLOs(value)
+		if err != nil {
+			invalidSetting("Invalid SLOS", "error", err)
+		}
+		config.SLOs = slos
+	}
+
+	// Shadow traffic to a secondary instance
+	config.MirrorURL = getenv("MIRROR_URL")
+	if value := getenv("MIRROR_RATE"); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 && f <= 1 {
+			config.MirrorRate = f
+		} else {
+			invalidSetting("Invalid MIRROR_RATE", "value", value)
+		}
+	}
+	config.MirrorInsecure = envBool("MIRROR_INSECURE", false)
+	config.MirrorProxy = envProxy("MIRROR_PROXY")
+
+	// Egress proxy for the clients above
+	config.OutboundProxy = envProxy("OUTBOUND_PROXY")
+
+	// External commands for site-specific policies and event handling
+	config.AuthHook = getenv("AUTH_HOOK")
+	config.AuthHookTimeout = envDuration("AUTH_HOOK_TIMEOUT", config.AuthHookTimeout)
+	config.AuthHookConcurrency = envInt("AUTH_HOOK_CONCURRENCY", config.AuthHookConcurrency)
+	config.AuthHookCacheTTL = envDuration("AUTH_HOOK_CACHE_TTL", config.AuthHookCacheTTL)
+	config.EventHook = getenv("EVENT_HOOK")
+
+	// Connections per client IP and window, counted in Redis when
+	// instances should share the limit
+	if limit := envNonNegativeInt("RATE_LIMIT", 0); limit > 0 {
+		window := envDuration("RATE_LIMIT_WINDOW", time.Minute)
+		if redisURL := getenv("RATE_LIMIT_REDIS_URL"); redisURL != "" {
+			limiter, err := server.NewRedisRateLimiter(redisURL, limit, window)
+			if err != nil {
+				invalidSetting("Invalid RATE_LIMIT_REDIS_URL", "error", err)
+			}
+			config.RateLimiter = limiter
+		} else {
+			config.RateLimiter = server.NewMemoryRateLimiter(limit, window)
+		}
+		slog.Info("Rate limit per IP", "connections", limit, "window", window)
+	}
+
+	// Temporary bans for IPs sending invalid signatures, in the server or
+	// by fail2ban reading the auth failure log
+	config.AuthFailureLog = getenv("AUTH_FAILURE_LOG")
+	config.BanThreshold = envNonNegativeInt("BAN_AFTER", 0)
+	config.BanWindow = envDuration("BAN_WINDOW", config.BanWindow)
+	config.BanDuration = envDuration("BAN_DURATION", config.BanDuration)
+	if config.BanThreshold > 0 {
+		slog.Info("Banning IPs after invalid signatures", "failures", config.BanThreshold,
+			"window", config.BanWindow, "duration", config.BanDuration)
+	}
+
+	// Self-monitoring for wedged requests, stalls and panics
+	config.WatchdogTimeout = envDuration("WATCHDOG_TIMEOUT", config.WatchdogTimeout)
+	if !envBool("WATCHDOG", true) {
+		config.WatchdogTimeout = 0
+	}
+	config.CrashReportDSN = getenv("CRASH_REPORT_DSN")
+
+	return config
+}
+
+// lockDown disables everything besides the ping endpoints
+func lockDown(config *server.Config) {
+	config.Landing = false
+	config.Favicon = false
+	config.RobotsTxt = false
+	config.WellKnownDir = ""
+	config.StaticDir = ""
+	config.AdminToken = ""
+	config.AdminTokens = nil
+	config.Metrics = false
+	config.HealthChecks = false
+	config.Whoami = false
+	config.TimeService = false
+	config.EventsFeed = false
+	config.Conformance = false
+	config.APISpec = false
+	config.ClientJS = false
+	config.MaintenanceFile = ""
+}
+
+// withDayOffset returns offsets with offset added unless already present
+func withDayOffset(offsets []int, offset int) []int {
+	for _, existing := range offsets {
+		if existing == offset {
+			return offsets
+		}
+	}
+	return append(offsets[:len(offsets):len(offsets)], offset)
+}
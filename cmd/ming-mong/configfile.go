@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fileSettings holds the settings loaded with -config, by environment
+// variable name
+var fileSettings map[string]string
+
+// getenv returns the setting name from a command-line flag, the
+// environment or the config file, in this order of precedence
+func getenv(name string) string {
+	if value, ok := flagSettings[name]; ok {
+		return value
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fileSettings[name]
+}
+
+// loadConfigFile reads settings from a YAML or TOML file, chosen by its
+// extension. Keys are the environment variable names in any case, and
+// sections prefix their keys, so tls.cert_file sets TLS_CERT_FILE. Lists
+// become comma-separated values.
+//
+// Only the subset of both formats needed for flat settings is supported:
+// scalars, quoted strings, lists and nested sections.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAMLSettings(lines)
+	case ".toml":
+		return parseTOMLSettings(lines)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+}
+
+// settingName maps a possibly nested key to its environment variable name
+func settingName(section, key string) string {
+	name := strings.NewReplacer(".", "_", "-", "_").Replace(strings.TrimSpace(key))
+	if section != "" {
+		name = section + "_" + name
+	}
+	return strings.ToUpper(name)
+}
+
+// settings collects values and rejects keys set twice
+type settings map[string]string
+
+func (s settings) set(line int, name, value string) error {
+	if _, ok := s[name]; ok {
+		return fmt.Errorf("line %d: %s set twice", line, name)
+	}
+	s[name] = value
+	return nil
+}
+
+// yamlSection is a key whose value is a nested block
+type yamlSection struct {
+	line   int
+	indent int
+	name   string
+	items  []string
+}
+
+func parseYAMLSettings(lines []string) (map[string]string, error) {
+	result := settings{}
+	var stack []*yamlSection
+
+	// A section that turned out to be a list becomes a setting when its
+	// block ends
+	pop := func() error {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if top.items != nil {
+			return result.set(top.line, top.name, strings.Join(top.items, ","))
+		}
+		return nil
+	}
+
+	for i, raw := range lines {
+		number := i + 1
+		line := strings.TrimRight(stripComment(raw), " \t")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", number)
+		}
+		indent := len(line) - len(content)
+
+		if item, ok := strings.CutPrefix(content, "-"); ok && (item == "" || item[0] == ' ') {
+			for len(stack) > 0 && stack[len(stack)-1].indent > indent {
+				if err := pop(); err != nil {
+					return nil, err
+				}
+			}
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: list item outside a key", number)
+			}
+			value, err := yamlScalar(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			top := stack[len(stack)-1]
+			top.items = append(top.items, value)
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			if err := pop(); err != nil {
+				return nil, err
+			}
+		}
+		key, value, ok := strings.Cut(content, ":")
+		if !ok || (value != "" && value[0] != ' ') {
+			return nil, fmt.Errorf("line %d: expected key: value", number)
+		}
+		section := ""
+		if len(stack) > 0 {
+			if stack[len(stack)-1].items != nil {
+				return nil, fmt.Errorf("line %d: key inside a list", number)
+			}
+			section = stack[len(stack)-1].name
+		}
+		name := settingName(section, key)
+
+		value = strings.TrimSpace(value)
+		if value == "" {
+			stack = append(stack, &yamlSection{line: number, indent: indent, name: name})
+			continue
+		}
+		value, err := yamlScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		if err := result.set(number, name, value); err != nil {
+			return nil, err
+		}
+	}
+	for len(stack) > 0 {
+		if err := pop(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// yamlScalar decodes a quoted string, a [flow, list] or a plain value
+func yamlScalar(value string) (string, error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return "", fmt.Errorf("unterminated list %s", value)
+		}
+		return joinList(value[1:len(value)-1], yamlScalar)
+	}
+	if strings.HasPrefix(value, "'") {
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	if strings.HasPrefix(value, `"`) {
+		return strconv.Unquote(value)
+	}
+	return value, nil
+}
+
+func parseTOMLSettings(lines []string) (map[string]string, error) {
+	result := settings{}
+	section := ""
+
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %s", number, line)
+			}
+			section = settingName("", line[1:len(line)-1])
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", number)
+		}
+		value = strings.TrimSpace(value)
+
+		// Arrays may span lines
+		if strings.HasPrefix(value, "[") {
+			for !strings.HasSuffix(value, "]") {
+				i++
+				if i == len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated array", number)
+				}
+				value += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+		}
+
+		value, err := tomlValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number, err)
+		}
+		if err := result.set(number, settingName(section, key), value); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// tomlValue decodes a string, an array or a bare number or boolean
+func tomlValue(value string) (string, error) {
+	switch {
+	case value == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(value, "["):
+		return joinList(strings.TrimSuffix(value[1:], "]"), tomlValue)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	}
+	return value, nil
+}
+
+// joinList decodes the comma-separated elements of a list and joins them
+// with plain commas
+func joinList(list string, decode func(string) (string, error)) (string, error) {
+	var values []string
+	for _, element := range splitOutsideQuotes(list, ',') {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		value, err := decode(element)
+		if err != nil {
+			return "", err
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, ","), nil
+}
+
+// stripComment removes a # comment that isn't inside a quoted string
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitOutsideQuotes splits s at sep, except inside quoted strings
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
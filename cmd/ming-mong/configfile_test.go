@@ -0,0 +1,217 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAMLSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "scalars",
+			input: "port: 8443\nrate_limit: 10\nlockdown: true\n---\n",
+			want:  map[string]string{"PORT": "8443", "RATE_LIMIT": "10", "LOCKDOWN": "true"},
+		},
+		{
+			name:  "key case and dashes",
+			input: "Tcp-Ping-Port: 8444",
+			want:  map[string]string{"TCP_PING_PORT": "8444"},
+		},
+		{
+			name:  "comments",
+			input: "# heading\nport: 8443 # trailing\n  # indented\nsecret: a#b",
+			want:  map[string]string{"PORT": "8443", "SECRET": "a#b"},
+		},
+		{
+			name:  "hash inside quotes",
+			input: "a: \"x # y\"\nb: 'x # y' # comment",
+			want:  map[string]string{"A": "x # y", "B": "x # y"},
+		},
+		{
+			name:  "double-quoted escapes",
+			input: `a: "tab\tquote\" backslash\\ # not a comment"`,
+			want:  map[string]string{"A": "tab\tquote\" backslash\\ # not a comment"},
+		},
+		{
+			name:  "single-quoted doubling",
+			input: `a: 'it''s \n raw'`,
+			want:  map[string]string{"A": `it's \n raw`},
+		},
+		{
+			name:  "empty strings",
+			input: "a: \"\"\nb: ''",
+			want:  map[string]string{"A": "", "B": ""},
+		},
+		{
+			name:  "nested sections",
+			input: "tls:\n  cert_file: server.crt\n  acme:\n    domains: example.com\nport: 8443",
+			want:  map[string]string{"TLS_CERT_FILE": "server.crt", "TLS_ACME_DOMAINS": "example.com", "PORT": "8443"},
+		},
+		{
+			name:  "dedent back to a parent section",
+			input: "a:\n  b:\n    c: 1\n  d: 2\ne: 3",
+			want:  map[string]string{"A_B_C": "1", "A_D": "2", "E": "3"},
+		},
+		{
+			name:  "block list",
+			input: "allow_cidrs:\n  - 10.0.0.0/8\n  - \"192.168.0.0/16\"\nport: 8443",
+			want:  map[string]string{"ALLOW_CIDRS": "10.0.0.0/8,192.168.0.0/16", "PORT": "8443"},
+		},
+		{
+			name:  "block list at the key's indent",
+			input: "allow_cidrs:\n- 10.0.0.0/8\n- 172.16.0.0/12",
+			want:  map[string]string{"ALLOW_CIDRS": "10.0.0.0/8,172.16.0.0/12"},
+		},
+		{
+			name:  "flow list with quoted commas",
+			input: `signing_keys: [v1:a, "v2:b,c", 'v3:d']`,
+			want:  map[string]string{"SIGNING_KEYS": "v1:a,v2:b,c,v3:d"},
+		},
+		{
+			name:  "value with colon",
+			input: "mirror_url: wss://backup:8443/ws",
+			want:  map[string]string{"MIRROR_URL": "wss://backup:8443/ws"},
+		},
+		{name: "set twice", input: "port: 1\nport: 2", err: "line 2: PORT set twice"},
+		{name: "set twice through a section", input: "tls_cert_file: a\ntls:\n  cert_file: b", err: "line 3: TLS_CERT_FILE set twice"},
+		{name: "tab indentation", input: "tls:\n\tcert_file: a", err: "line 2: tabs are not allowed"},
+		{name: "missing colon", input: "port 8443", err: "line 1: expected key: value"},
+		{name: "no space after colon", input: "port:8443", err: "line 1: expected key: value"},
+		{name: "list outside a key", input: "- a", err: "line 1: list item outside a key"},
+		{name: "key inside a list", input: "a:\n  - x\n  b: y", err: "line 3: key inside a list"},
+		{name: "unterminated single quote", input: "a: 'x", err: "line 1: unterminated string"},
+		{name: "unterminated double quote", input: `a: "x`, err: "line 1:"},
+		{name: "unterminated flow list", input: "a: [x, y", err: "line 1: unterminated list"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAMLSettings(strings.Split(test.input, "\n"))
+			checkSettings(t, got, err, test.want, test.err)
+		})
+	}
+}
+
+func TestParseTOMLSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "bare values",
+			input: "port = 8443\nlockdown = true\nratio = 0.5",
+			want:  map[string]string{"PORT": "8443", "LOCKDOWN": "true", "RATIO": "0.5"},
+		},
+		{
+			name:  "comments",
+			input: "# heading\nport = 8443 # trailing\nsecret = \"a # b\" # comment",
+			want:  map[string]string{"PORT": "8443", "SECRET": "a # b"},
+		},
+		{
+			name:  "basic string escapes",
+			input: `a = "tab\tquote\" \u00e9"`,
+			want:  map[string]string{"A": "tab\tquote\" é"},
+		},
+		{
+			name:  "literal string",
+			input: `a = 'C:\path\n # x'`,
+			want:  map[string]string{"A": `C:\path\n # x`},
+		},
+		{
+			name:  "tables",
+			input: "port = 1\n[tls]\ncert_file = \"server.crt\"\n[tls.acme]\ndomains = \"example.com\"",
+			want:  map[string]string{"PORT": "1", "TLS_CERT_FILE": "server.crt", "TLS_ACME_DOMAINS": "example.com"},
+		},
+		{
+			name:  "arrays",
+			input: `allow_cidrs = ["10.0.0.0/8", '192.168.0.0/16', "a,b"]`,
+			want:  map[string]string{"ALLOW_CIDRS": "10.0.0.0/8,192.168.0.0/16,a,b"},
+		},
+		{
+			name:  "multiline array with comments and a trailing comma",
+			input: "allow_cidrs = [\n  \"10.0.0.0/8\", # office\n  \"172.16.0.0/12\",\n]\nport = 1",
+			want:  map[string]string{"ALLOW_CIDRS": "10.0.0.0/8,172.16.0.0/12", "PORT": "1"},
+		},
+		{
+			name:  "empty array",
+			input: "allow_cidrs = []",
+			want:  map[string]string{"ALLOW_CIDRS": ""},
+		},
+		{name: "set twice", input: "port = 1\nport = 2", err: "line 2: PORT set twice"},
+		{name: "set twice through a table", input: "tls_cert_file = 'a'\n[tls]\ncert_file = 'b'", err: "line 3: TLS_CERT_FILE set twice"},
+		{name: "missing equals", input: "port 8443", err: "line 1: expected key = value"},
+		{name: "missing value", input: "port =", err: "line 1: missing value"},
+		{name: "array of tables", input: "[[keys]]", err: "line 1: invalid table header"},
+		{name: "unterminated table header", input: "[tls", err: "line 1: invalid table header"},
+		{name: "unterminated array", input: "a = [\n\"x\"", err: "line 1: unterminated array"},
+		{name: "unterminated literal string", input: "a = 'x", err: "line 1: unterminated string"},
+		{name: "unterminated basic string", input: `a = "x`, err: "line 1:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOMLSettings(strings.Split(test.input, "\n"))
+			checkSettings(t, got, err, test.want, test.err)
+		})
+	}
+}
+
+func checkSettings(t *testing.T, got map[string]string, err error, want map[string]string, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("got error %v, want %q", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestStripComment(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"key: value", "key: value"},
+		{"key: value # comment", "key: value "},
+		{"# whole line", ""},
+		{"key: a#b", "key: a#b"},
+		{"key: value\t# after a tab", "key: value\t"},
+		{`key: "a # b" # c`, `key: "a # b" `},
+		{`key: 'a # b' # c`, `key: 'a # b' `},
+		{`key: "escaped \" # still quoted" # c`, `key: "escaped \" # still quoted" `},
+		{`key: 'back\' # c`, `key: 'back\' `},
+	}
+	for _, test := range tests {
+		if got := stripComment(test.line); got != test.want {
+			t.Errorf("stripComment(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestSplitOutsideQuotes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a,b,c", []string{"a", "b", "c"}},
+		{"", []string{""}},
+		{"a,", []string{"a", ""}},
+		{`"a,b",c`, []string{`"a,b"`, "c"}},
+		{`'a,b',c`, []string{`'a,b'`, "c"}},
+		{`"a\",b",c`, []string{`"a\",b"`, "c"}},
+	}
+	for _, test := range tests {
+		if got := splitOutsideQuotes(test.s, ','); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitOutsideQuotes(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
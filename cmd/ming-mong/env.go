@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ming-mong/server"
+)
+
+// envBool reads a boolean setting, accepting true/1/yes/on and
+// false/0/no/off; unset variables return def
+func envBool(name string, def bool) bool {
+	value := strings.ToLower(getenv(name))
+	switch value {
+	case "":
+		return def
+	case "true", "1", "yes", "on":
+		return true
+	case "false", "0", "no", "off":
+		return false
+	}
+	invalidSetting("Invalid "+name, "value", value)
+	return false
+}
+
+// envInt reads a positive integer setting; unset variables return def
+func envInt(name string, def int) int {
+	value := getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		invalidSetting("Invalid "+name, "value", value)
+	}
+	return n
+}
+
+// envNonNegativeInt is envInt for settings where 0 means unlimited,
+// disabled or the OS default
+func envNonNegativeInt(name string, def int) int {
+	value := getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		invalidSetting("Invalid "+name, "value", value)
+	}
+	return n
+}
+
+// envDuration reads a positive duration setting such as "5s"; unset
+// variables return def
+func envDuration(name string, def time.Duration) time.Duration {
+	value := getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		invalidSetting("Invalid "+name, "value", value)
+	}
+	return d
+}
+
+// envProxy reads an outbound proxy URL; unset variables return nil
+func envProxy(name string) *url.URL {
+	value := getenv(name)
+	if value == "" {
+		return nil
+	}
+	proxy, err := server.ParseProxyURL(value)
+	if err != nil {
+		invalidSetting("Invalid "+name, "error", err)
+	}
+	return proxy
+}
+
+// settingError is an invalid setting. The env helpers and configFromEnv
+// panic with it, so startup can exit while a reload keeps running on the
+// previous settings.
+type settingError struct {
+	msg  string
+	args []any
+}
+
+func (e settingError) Error() string {
+	var message strings.Builder
+	message.WriteString(e.msg)
+	for i := 0; i+1 < len(e.args); i += 2 {
+		fmt.Fprintf(&message, " %v=%v", e.args[i], e.args[i+1])
+	}
+	return message.String()
+}
+
+func invalidSetting(msg string, args ...any) {
+	panic(settingError{msg: msg, args: args})
+}
+
+// exitOnSettingError turns a settingError panic into a fatal log line;
+// deferred in main
+func exitOnSettingError() {
+	if r := recover(); r != nil {
+		if err, ok := r.(settingError); ok {
+			fatal(err.msg, err.args...)
+		}
+		panic(r)
+	}
+}
+
+// loadConfig is configFromEnv returning invalid settings as an error, for
+// reloads. LOCKDOWN is applied here as well as on startup, or a reload
+// would bring back the endpoints and files it turned off.
+func loadConfig(port string) (config server.Config, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			settingErr, ok := r.(settingError)
+			if !ok {
+				panic(r)
+			}
+			err = settingErr
+		}
+	}()
+	config = configFromEnv(port)
+	if envBool("LOCKDOWN", false) {
+		lockDown(&config)
+	}
+	return config, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "flag"
+
+// flagSettings holds the settings given on the command line, by
+// environment variable name. They take precedence over the environment.
+var flagSettings = map[string]string{}
+
+// settingFlag is a command-line flag setting an environment variable
+type settingFlag struct {
+	setting string
+	isBool  bool
+}
+
+func (f settingFlag) String() string { return "" }
+
+func (f settingFlag) Set(value string) error {
+	flagSettings[f.setting] = value
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool { return f.isBool }
+
+// addSettingFlags registers the flags for the settings most often changed
+// in ad-hoc runs
+func addSettingFlags(flags *flag.FlagSet) {
+	flags.Var(settingFlag{setting: "PORT"}, "port", "`port` to listen on (PORT)")
+	flags.Var(settingFlag{setting: "TLS_CERT_FILE"}, "tls-cert", "TLS certificate `file` (TLS_CERT_FILE)")
+	flags.Var(settingFlag{setting: "TLS_KEY_FILE"}, "tls-key", "TLS private key `file` (TLS_KEY_FILE)")
+	flags.Var(settingFlag{setting: "ENABLE_TLS", isBool: true}, "enable-tls", "enable TLS (ENABLE_TLS)")
+	flags.Var(settingFlag{setting: "LOG_LEVEL"}, "log-level", "log `level`: debug, info, warn or error (LOG_LEVEL)")
+}
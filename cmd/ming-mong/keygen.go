@@ -0,0 +1,21 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// runKeygen implements `ming-mong keygen`: it prints a key pair for sealed
+// messages, the private key for ENCRYPTION_KEY or a client and the public
+// key for the other side
+func runKeygen() {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		fatal("Failed to generate key pair", "error", err)
+	}
+	fmt.Printf("private_key: %s\n", base64.StdEncoding.EncodeToString(privateKey[:]))
+	fmt.Printf("public_key:  %s\n", base64.StdEncoding.EncodeToString(publicKey[:]))
+}
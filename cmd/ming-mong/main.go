@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"ming-mong/server"
+)
+
+func main() {
+	defer exitOnSettingError()
+
+	if err := setupLogging(); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		runUpdate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ping" {
+		runPing(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "profile" {
+		runProfile(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		runKeygen()
+		return
+	}
+
+	// `ming-mong check` validates the configuration: it sets everything up
+	// including the listeners, then exits instead of serving
+	dryRun := len(os.Args) > 1 && os.Args[1] == "check"
+	args := os.Args[1:]
+	if dryRun {
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet("ming-mong", flag.ExitOnError)
+	configFile := flags.String("config", "", "YAML or TOML file with settings; environment variables override it")
+	addSettingFlags(flags)
+	flags.Parse(args)
+
+	if *configFile != "" {
+		settings, err := loadConfigFile(*configFile)
+		if err != nil {
+			fatal("Invalid config file", "file", *configFile, "error", err)
+		}
+		fileSettings = settings
+	}
+
+	// Flags and the config file may set up logging as well
+	if err := setupLogging(); err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	if *configFile != "" {
+		slog.Info("Loaded config file", "file", *configFile, "settings", len(fileSettings))
+	}
+
+	// Get port from environment variable
+	port := getenv("PORT")
+	if port == "" {
+		port = "8443"
+	}
+
+	// Validate port
+	if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+		fatal("Invalid PORT", "value", port)
+	}
+
+	config := configFromEnv(port)
+	if dryRun {
+		config.SelfCheck = false
+	}
+
+	// Determine if we should use TLS
+	useTLS := envBool("ENABLE_TLS", false)
+	certFile := getenv("TLS_CERT_FILE")
+	keyFile := getenv("TLS_KEY_FILE")
+
+	// Auto-detect TLS if cert files are provided
+	if certFile != "" && keyFile != "" {
+		if _, err := os.Stat(certFile); err == nil {
+			if _, err := os.Stat(keyFile); err == nil {
+				useTLS = true
+			}
+		}
+	}
+
+	// ACME obtains the certificates itself
+	if len(config.ACMEDomains) > 0 {
+		useTLS = true
+	}
+
+	// Default cert/key files if not specified
+	tlsMissing := false
+	defaultFiles := useTLS && len(config.ACMEDomains) == 0 && (certFile == "" || keyFile == "")
+	if defaultFiles {
+		certFile = "server.crt"
+		keyFile = "server.key"
+	}
+
+	// A self-signed certificate is generated rather than falling back to
+	// plain HTTP when neither file exists yet
+	if useTLS && len(config.ACMEDomains) == 0 && missingFile(certFile) && missingFile(keyFile) && envBool("TLS_SELF_SIGNED", true) {
+		sans := defaultSANs()
+		if value := getenv("TLS_SELF_SIGNED_SANS"); value != "" {
+			sans = parseSANs(value)
+		}
+		if err := generateSelfSigned(certFile, keyFile, sans); err != nil {
+			fatal("Failed to generate self-signed certificate", "cert", certFile, "key", keyFile, "error", err)
+		}
+		slog.Info("Generated self-signed certificate", "cert", certFile, "key", keyFile, "sans", strings.Join(sans, ","))
+	}
+
+	// Check if default files exist
+	if defaultFiles {
+		if _, err := os.Stat(certFile); err != nil {
+			useTLS = false
+			slog.Warn("TLS requested but cert file not found", "file", certFile)
+		}
+		if _, err := os.Stat(keyFile); err != nil {
+			useTLS = false
+			slog.Warn("TLS requested but key file not found", "file", keyFile)
+		}
+		tlsMissing = !useTLS
+	}
+	if useTLS && len(config.ACMEDomains) == 0 {
+		config.CertFile = certFile
+		config.KeyFile = keyFile
+	}
+
+	// Favicon and robots.txt default to on when the landing page is served
+	landingEnabled := useTLS && config.Landing
+	config.Favicon = envBool("FAVICON", landingEnabled)
+	config.RobotsTxt = envBool("ROBOTS_TXT", landingEnabled)
+
+	// Lockdown mode serves nothing but the ping endpoint
+	if envBool("LOCKDOWN", false) {
+		slog.Info("Lockdown mode - only ping endpoints are served, all optional endpoints are disabled")
+		lockDown(&config)
+	}
+
+	// The TCP ping socket is handed over in a graceful restart like the
+	// listeners below
+	if config.TCPPingAddr != "" {
+		ln, err := inheritedTCPPingListener(config.TCP)
+		if err != nil {
+			fatal("Failed to inherit listeners", "error", err)
+		}
+		if ln == nil {
+			if ln, err = server.Listen(config.TCPPingAddr, config.TCP, false); err != nil {
+				fatal("Failed to listen for TCP pings", "addr", config.TCPPingAddr, "error", err)
+			}
+		}
+		config.TCPPingListener = ln
+	}
+
+	srv, err := server.New(config)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	if tlsMissing {
+		srv.SetHealthProblem("tls", server.HealthFailing, "TLS requested but certificate files not found")
+	}
+
+	slog.Info("Ming-Mong WebSocket server starting", "port", port, "version", server.Version)
+
+	// Listening sockets are inherited during a graceful restart, otherwise
+	// several SO_REUSEPORT listeners may spread accepts across cores
+	listeners, err := inheritedListeners(config.TCP)
+	if err != nil {
+		fatal("Failed to inherit listeners", "error", err)
+	}
+	if listeners != nil {
+		slog.Info("Inherited listeners from previous process", "listeners", len(listeners))
+	} else {
+		listenerCount := envInt("LISTENERS", 1)
+		if listenerCount > 1 && !server.ReusePortSupported {
+			fatal("LISTENERS > 1 requires SO_REUSEPORT support (Linux)", "listeners", listenerCount)
+		}
+
+		listeners = make([]net.Listener, listenerCount)
+		for i := range listeners {
+			ln, err := server.Listen(config.Addr, config.TCP, listenerCount > 1)
+			if err != nil {
+				fatal("Failed to listen", "port", port, "error", err)
+			}
+			listeners[i] = ln
+		}
+		if listenerCount > 1 {
+			slog.Info("Accepting on SO_REUSEPORT listeners", "listeners", listenerCount)
+		}
+	}
+
+	if srv.TLS() {
+		if len(config.ACMEDomains) > 0 {
+			slog.Info("TLS enabled", "acme_domains", strings.Join(config.ACMEDomains, ","))
+		} else {
+			slog.Info("TLS enabled", "cert", certFile, "key", keyFile)
+		}
+		slog.Info("WebSocket endpoint: wss://localhost:" + port + "/ws")
+		slog.Info("Security: Encrypted WebSocket connections (WSS)")
+	} else {
+		slog.Info("TLS disabled - using plain HTTP")
+		slog.Info("WebSocket endpoint: ws://localhost:" + port + "/ws")
+		slog.Info("Security: Plain WebSocket connections (WS)")
+	}
+
+	if dryRun {
+		if config.TCPPingListener != nil {
+			config.TCPPingListener.Close()
+		}
+		finishDryRun(srv, listeners)
+		return
+	}
+
+	// Record the PID so supervisors can follow restarts
+	if pidFile := getenv("PID_FILE"); pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			fatal("Failed to write PID_FILE", "error", err)
+		}
+	}
+
+	// SIGTERM and SIGINT stop the server after draining, SIGUSR2 hands
+	// the listeners to a new process first
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	watchShutdown(stop)
+	watchRestart(listeners, config.TCPPingListener, stop)
+	watchReload(srv, *configFile, port)
+
+	// The new process takes over from here when restarting
+	notifyReady()
+
+	if err := srv.Serve(ctx, listeners...); err != nil {
+		fatal("Server failed", "error", err)
+	}
+	slog.Info("Connections drained, exiting")
+}
+
+// watchShutdown calls stop on SIGINT and SIGTERM
+func watchShutdown(stop context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		slog.Info("Shutting down", "signal", sig.String())
+		stop()
+	}()
+}
+
+// finishDryRun reports the outcome of `ming-mong check`, failing when any
+// component reported a problem during setup
+func finishDryRun(srv *server.Server, listeners []net.Listener) {
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	srv.Close()
+
+	level, reasons := srv.Health()
+	if level != server.HealthOK {
+		for _, reason := range reasons {
+			slog.Error("Problem", "reason", reason)
+		}
+		fatal("Configuration check failed")
+	}
+	slog.Info("Configuration OK")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"ming-mong/client"
+)
+
+// runPing implements `ming-mong ping <url>`: it pings a remote server at an
+// interval and prints RTT statistics like the classic ping tool
+func runPing(args []string) {
+	flags := flag.NewFlagSet("ping", flag.ExitOnError)
+	count := flags.Int("c", 0, "stop after this many pings (default: until interrupted)")
+	interval := flags.Duration("i", time.Second, "time between pings")
+	timeout := flags.Duration("W", 5*time.Second, "time to wait for each pong")
+	keyID := flags.String("key-id", "", "named secret to sign with, requires -secret")
+	clientID := flags.String("client-id", "", "client ID to sign as, with its secret in -secret")
+	secret := flags.String("secret", "", "server MING_MONG_SECRET, or the secret of -key-id or -client-id (default: built-in)")
+	nonce := flags.Bool("nonce", false, "sign a random nonce so the ping can't be replayed (see NONCE_REQUIRED)")
+	insecure := flags.Bool("k", false, "skip certificate verification")
+	serverKey := flags.String("server-key", "", "seal pings to this server public key (see ENCRYPTION_KEY)")
+	privateKey := flags.String("private-key", "", "client private key for -server-key (default: a new one per ping)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ming-mong ping [flags] <url>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	url := flags.Arg(0)
+
+	opts := client.Options{
+		KeyID:    *keyID,
+		ClientID: *clientID,
+		Secret:   *secret,
+		Nonce:    *nonce,
+		Insecure: *insecure,
+		Timeout:  *timeout,
+		Header:   http.Header{"User-Agent": {"ming-mong-ping"}},
+	}
+	if *serverKey != "" {
+		key, err := client.ParseKey(*serverKey)
+		if err != nil {
+			fatal("Invalid -server-key", "error", err)
+		}
+		opts.ServerKey = key
+	}
+	if *privateKey != "" {
+		key, err := client.ParseKey(*privateKey)
+		if err != nil {
+			fatal("Invalid -private-key", "error", err)
+		}
+		opts.PrivateKey = key
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("PING %s\n", url)
+	var stats rttStats
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for seq := 1; *count == 0 || seq <= *count; seq++ {
+		result, err := client.Ping(ctx, url, opts)
+		if ctx.Err() != nil {
+			break
+		}
+		stats.sent++
+
+		var serverErr *client.ServerError
+		switch {
+		case errors.As(err, &serverErr):
+			fmt.Printf("error from %s: seq=%d %s\n", url, seq, serverErr.Code)
+		case err != nil:
+			fmt.Printf("no pong from %s: seq=%d %v\n", url, seq, err)
+		default:
+			stats.add(result.RTT)
+			fmt.Printf("pong from %s: seq=%d status=%s%s rtt=%s connect=%s%s\n",
+				url, seq, result.Status, reasonsNote(result.Reasons), formatMs(result.RTT), formatMs(result.Connect), skewNote(result.ClockSkew))
+		}
+
+		if *count != 0 && seq == *count {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	stats.print(url)
+	if stats.received == 0 {
+		os.Exit(1)
+	}
+}
+
+// rttStats accumulates the summary printed when pinging stops
+type rttStats struct {
+	sent, received int
+	min, max       time.Duration
+	sum, sumSq     float64
+}
+
+func (s *rttStats) add(rtt time.Duration) {
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.received++
+	ms := float64(rtt) / float64(time.Millisecond)
+	s.sum += ms
+	s.sumSq += ms * ms
+}
+
+func (s *rttStats) print(url string) {
+	fmt.Printf("\n--- %s ping statistics ---\n", url)
+	loss := 0.0
+	if s.sent > 0 {
+		loss = float64(s.sent-s.received) / float64(s.sent) * 100
+	}
+	fmt.Printf("%d pings sent, %d pongs received, %.1f%% loss\n", s.sent, s.received, loss)
+	if s.received == 0 {
+		return
+	}
+
+	avg := s.sum / float64(s.received)
+	mdev := math.Sqrt(math.Max(s.sumSq/float64(s.received)-avg*avg, 0))
+	fmt.Printf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+		float64(s.min)/float64(time.Millisecond), avg, float64(s.max)/float64(time.Millisecond), mdev)
+}
+
+func formatMs(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
+
+// reasonsNote names the failing server components of a degraded pong
+func reasonsNote(reasons []string) string {
+	if len(reasons) == 0 {
+		return ""
+	}
+	return " reasons=" + strings.Join(reasons, ",")
+}
+
+func skewNote(skew time.Duration) string {
+	if skew == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" clock_skew=%s", skew)
+}
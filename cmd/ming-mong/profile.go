@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"ming-mong/client"
+	"ming-mong/server"
+)
+
+// runProfile implements `ming-mong profile`: it starts a server with the
+// default configuration on the loopback interface, pings it from several
+// workers for a while and writes CPU and heap profiles along with an HTML
+// report of the latency percentiles, so releases can be compared on the
+// same hardware
+func runProfile(args []string) {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	duration := flags.Duration("duration", 60*time.Second, "how long to generate load")
+	workers := flags.Int("concurrency", runtime.NumCPU(), "number of clients pinging in parallel")
+	outDir := flags.String("out", "ming-mong-profile", "directory for report.html, cpu.pprof and heap.pprof")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: ming-mong profile [flags]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *duration <= 0 || *workers <= 0 || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		fatal("Failed to create output directory", "dir", *outDir, "error", err)
+	}
+
+	// Every ping would otherwise be logged, measuring the terminal rather
+	// than the server
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config := server.DefaultConfig()
+	config.Addr = "127.0.0.1:0"
+	srv, err := server.New(config)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	ln, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		fatal("Failed to listen", "error", err)
+	}
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(serveCtx, ln) }()
+	url := "ws://" + ln.Addr().String() + "/ws"
+
+	var cpuProfile bytes.Buffer
+	if err := pprof.StartCPUProfile(&cpuProfile); err != nil {
+		fatal("Failed to start CPU profile", "error", err)
+	}
+	fmt.Printf("Profiling %s for %s with %d clients\n", url, *duration, *workers)
+	run := generateLoad(ctx, url, *duration, *workers)
+	pprof.StopCPUProfile()
+
+	runtime.GC()
+	var heapProfile bytes.Buffer
+	if err := pprof.WriteHeapProfile(&heapProfile); err != nil {
+		fatal("Failed to write heap profile", "error", err)
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stopServing()
+	<-served
+
+	report := newProfileReport(run, *workers, &mem)
+	files := map[string][]byte{"cpu.pprof": cpuProfile.Bytes(), "heap.pprof": heapProfile.Bytes()}
+	var html bytes.Buffer
+	if err := profileTemplate.Execute(&html, report); err != nil {
+		fatal("Failed to render report", "error", err)
+	}
+	files["report.html"] = html.Bytes()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(*outDir, name), data, 0644); err != nil {
+			fatal("Failed to write profile output", "file", name, "error", err)
+		}
+	}
+
+	fmt.Printf("%d pings, %d errors, %.0f pings/s, rtt p50/p99 = %s/%s\n",
+		report.Pings, report.Errors, report.Rate, report.RTT[0].Value, report.RTT[2].Value)
+	fmt.Printf("Report written to %s\n", filepath.Join(*outDir, "report.html"))
+}
+
+// loadRun is what the workers of a profile run measured
+type loadRun struct {
+	elapsed  time.Duration
+	rtt      []time.Duration
+	connect  []time.Duration
+	errors   map[string]int
+	canceled bool
+}
+
+// generateLoad pings url from workers goroutines until duration passes or
+// ctx is canceled; each ping dials its own connection as clients do
+func generateLoad(ctx context.Context, url string, duration time.Duration, workers int) loadRun {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	opts := client.Options{Header: http.Header{"User-Agent": {"ming-mong-profile"}}}
+	run := loadRun{errors: make(map[string]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var rtt, connect []time.Duration
+			failures := make(map[string]int)
+			for ctx.Err() == nil {
+				result, err := client.Ping(ctx, url, opts)
+				if ctx.Err() != nil {
+					break
+				}
+				if err != nil {
+					failures[err.Error()]++
+					continue
+				}
+				rtt = append(rtt, result.RTT)
+				connect = append(connect, result.Connect)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			run.rtt = append(run.rtt, rtt...)
+			run.connect = append(run.connect, connect...)
+			for msg, n := range failures {
+				run.errors[msg] += n
+			}
+		}()
+	}
+	wg.Wait()
+	run.elapsed = time.Since(start)
+	run.canceled = run.elapsed < duration
+	return run
+}
+
+// profileReport is rendered into report.html
+type profileReport struct {
+	Version    string
+	GoVersion  string
+	Platform   string
+	CPUs       int
+	Started    string
+	Duration   string
+	Canceled   bool
+	Workers    int
+	Pings      int
+	Errors     int
+	Rate       float64
+	RTT        []percentile
+	Connect    []percentile
+	Histogram  []histogramBar
+	ErrorKinds []errorKind
+	HeapInUse  string
+	TotalAlloc string
+	GCCycles   uint32
+	GCPause    string
+}
+
+type percentile struct {
+	Name  string
+	Value string
+}
+
+// histogramBar is one power-of-two RTT bucket; Width is relative to the
+// fullest bucket
+type histogramBar struct {
+	Label string
+	Count int
+	Width int
+}
+
+type errorKind struct {
+	Message string
+	Count   int
+}
+
+func newProfileReport(run loadRun, workers int, mem *runtime.MemStats) profileReport {
+	report := profileReport{
+		Version:    server.Version,
+		GoVersion:  runtime.Version(),
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		CPUs:       runtime.NumCPU(),
+		Started:    time.Now().Add(-run.elapsed).UTC().Format(time.RFC3339),
+		Duration:   run.elapsed.Round(time.Millisecond).String(),
+		Canceled:   run.canceled,
+		Workers:    workers,
+		Pings:      len(run.rtt),
+		RTT:        percentiles(run.rtt),
+		Connect:    percentiles(run.connect),
+		Histogram:  histogram(run.rtt),
+		HeapInUse:  formatBytes(mem.HeapInuse),
+		TotalAlloc: formatBytes(mem.TotalAlloc),
+		GCCycles:   mem.NumGC,
+		GCPause:    time.Duration(mem.PauseTotalNs).String(),
+	}
+	if seconds := run.elapsed.Seconds(); seconds > 0 {
+		report.Rate = float64(len(run.rtt)) / seconds
+	}
+	for msg, n := range run.errors {
+		report.Errors += n
+		report.ErrorKinds = append(report.ErrorKinds, errorKind{Message: msg, Count: n})
+	}
+	sort.Slice(report.ErrorKinds, func(i, j int) bool { return report.ErrorKinds[i].Count > report.ErrorKinds[j].Count })
+	return report
+}
+
+// percentiles sorts samples and reports p50, p90, p99, p99.9 and the
+// maximum
+func percentiles(samples []time.Duration) []percentile {
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(q float64) string {
+		if len(samples) == 0 {
+			return "-"
+		}
+		return formatMs(samples[int(q*float64(len(samples)-1))])
+	}
+	return []percentile{
+		{"p50", at(0.5)},
+		{"p90", at(0.9)},
+		{"p99", at(0.99)},
+		{"p99.9", at(0.999)},
+		{"max", at(1)},
+	}
+}
+
+// histogram buckets samples by powers of two microseconds
+func histogram(samples []time.Duration) []histogramBar {
+	var counts []int
+	for _, sample := range samples {
+		bucket := 0
+		for limit := time.Microsecond; sample >= limit; limit *= 2 {
+			bucket++
+		}
+		for len(counts) <= bucket {
+			counts = append(counts, 0)
+		}
+		counts[bucket]++
+	}
+
+	fullest := 0
+	for _, n := range counts {
+		fullest = max(fullest, n)
+	}
+	var bars []histogramBar
+	for bucket, n := range counts {
+		if n == 0 {
+			continue
+		}
+		limit := time.Microsecond << bucket
+		bars = append(bars, histogramBar{Label: "< " + limit.String(), Count: n, Width: n * 100 / fullest})
+	}
+	return bars
+}
+
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
+
+var profileTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ming-mong {{.Version}} profile</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 0.25em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+.bar { background: #4a90d9; height: 0.9em; }
+code { background: #f4f4f4; padding: 0.1em 0.3em; }
+</style>
+</head>
+<body>
+<h1>ming-mong {{.Version}} profile</h1>
+<table>
+<tr><th>Started</th><td>{{.Started}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}{{if .Canceled}} (interrupted){{end}}</td></tr>
+<tr><th>Clients</th><td>{{.Workers}}</td></tr>
+<tr><th>Platform</th><td>{{.Platform}}, {{.CPUs}} CPUs, {{.GoVersion}}</td></tr>
+<tr><th>Pings</th><td>{{.Pings}} ({{printf "%.0f" .Rate}}/s)</td></tr>
+<tr><th>Errors</th><td>{{.Errors}}</td></tr>
+</table>
+
+<h2>Latency</h2>
+<table>
+<tr><th></th>{{range .RTT}}<th>{{.Name}}</th>{{end}}</tr>
+<tr><th>RTT</th>{{range .RTT}}<td>{{.Value}}</td>{{end}}</tr>
+<tr><th>Connect</th>{{range .Connect}}<td>{{.Value}}</td>{{end}}</tr>
+</table>
+
+<h2>RTT distribution</h2>
+<table>
+{{range .Histogram}}<tr><td>{{.Label}}</td><td>{{.Count}}</td><td style="width: 30em"><div class="bar" style="width: {{.Width}}%"></div></td></tr>
+{{end}}</table>
+{{if .ErrorKinds}}
+<h2>Errors</h2>
+<table>
+{{range .ErrorKinds}}<tr><td>{{.Count}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Memory</h2>
+<table>
+<tr><th>Heap in use</th><td>{{.HeapInUse}}</td></tr>
+<tr><th>Total allocated</th><td>{{.TotalAlloc}}</td></tr>
+<tr><th>GC cycles</th><td>{{.GCCycles}} ({{.GCPause}} paused)</td></tr>
+</table>
+
+<h2>Profiles</h2>
+<p>The clients run in the same process as the server, so both show up in the profiles.</p>
+<p><code>go tool pprof -http=: cpu.pprof</code><br><code>go tool pprof -http=: heap.pprof</code></p>
+</body>
+</html>
+`))
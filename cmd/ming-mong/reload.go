@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"ming-mong/server"
+)
+
+// watchReload applies a changed config file and certificates on SIGHUP,
+// without touching the listeners. Only the settings server.Reload
+// supports take effect; the rest need a graceful restart.
+func watchReload(srv *server.Server, configFile, port string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			slog.Info("Reload requested")
+			if err := reload(srv, configFile, port); err != nil {
+				slog.Error("Reload failed, keeping current configuration", "error", err)
+				continue
+			}
+			slog.Info("Configuration reloaded")
+		}
+	}()
+}
+
+func reload(srv *server.Server, configFile, port string) error {
+	previous := fileSettings
+	if configFile != "" {
+		settings, err := loadConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		fileSettings = settings
+	}
+
+	config, err := loadConfig(port)
+	if err == nil {
+		err = srv.Reload(config)
+	}
+	if err != nil {
+		fileSettings = previous
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ming-mong/client"
+	"ming-mong/server"
+)
+
+func TestReloadKeepsLockdown(t *testing.T) {
+	dir := t.TempDir()
+	flag := filepath.Join(dir, "maintenance")
+	if err := os.WriteFile(flag, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "ming-mong.yaml")
+	settings := "maintenance_file: " + flag + "\nmetrics: true\nstatic_dir: " + dir + "\nadmin_token: secret\n"
+	if err := os.WriteFile(configFile, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOCKDOWN", "true")
+	previous := fileSettings
+	t.Cleanup(func() { fileSettings = previous })
+
+	srv, err := server.New(server.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := server.NewPipeListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if err := reload(srv, configFile, "8443"); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	// The maintenance file LOCKDOWN turns off stays off
+	result, err := client.Ping(ctx, "ws://ming-mong.test/ws", client.Options{NetDialContext: ln.DialContext})
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if result.Status != "ok" {
+		t.Fatalf("got status %q after reload, want ok", result.Status)
+	}
+
+	config, err := loadConfig("8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaintenanceFile != "" || config.Metrics || config.StaticDir != "" || config.AdminToken != "" {
+		t.Fatalf("reloaded config escaped lockdown: maintenance file %q, metrics %v, static dir %q, admin token set %v",
+			config.MaintenanceFile, config.Metrics, config.StaticDir, config.AdminToken != "")
+	}
+}
@@ -0,0 +1,219 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ming-mong/server"
+)
+
+// Environment variables passed to the replacement process. Inherited
+// listeners start at fd 3, the TCP ping listener and the readiness pipe
+// follow them.
+const (
+	listenFdsEnv = "MING_MONG_LISTEN_FDS"
+	tcpPingFdEnv = "MING_MONG_TCP_PING_FD"
+	readyFdEnv   = "MING_MONG_READY_FD"
+)
+
+// inheritedListeners returns the listeners handed over by the process being
+// replaced, or nil when this is a fresh start
+func inheritedListeners(options server.TCPOptions) ([]net.Listener, error) {
+	value := os.Getenv(listenFdsEnv)
+	if value == "" {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid %s: %s", listenFdsEnv, value)
+	}
+
+	listeners := make([]net.Listener, count)
+	for i := range listeners {
+		file := os.NewFile(uintptr(3+i), "listener")
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited listener %d: %w", i, err)
+		}
+		listeners[i] = server.TuneListener(ln, options)
+	}
+	return listeners, nil
+}
+
+// inheritedTCPPingListener returns the TCP ping listener handed over by the
+// process being replaced, or nil when there is none
+func inheritedTCPPingListener(options server.TCPOptions) (net.Listener, error) {
+	value := os.Getenv(tcpPingFdEnv)
+	if value == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return nil, fmt.Errorf("invalid %s: %s", tcpPingFdEnv, value)
+	}
+
+	file := os.NewFile(uintptr(fd), "tcp ping listener")
+	ln, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inherited TCP ping listener: %w", err)
+	}
+	return server.TuneListener(ln, options), nil
+}
+
+// notifyReady tells the process being replaced that this one is serving
+// and it can start draining
+func notifyReady() {
+	value := os.Getenv(readyFdEnv)
+	if value == "" {
+		return
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+
+	pipe := os.NewFile(uintptr(fd), "ready")
+	pipe.Write([]byte{1})
+	pipe.Close()
+}
+
+// watchRestart replaces the process on SIGUSR2: a new copy of the binary
+// inherits the listening sockets, including tcpPing when set, and once it
+// reports ready stop is called so this process drains its in-flight
+// connections and exits
+func watchRestart(listeners []net.Listener, tcpPing net.Listener, stop context.CancelFunc) {
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGUSR2)
+
+		for range signals {
+			slog.Info("Graceful restart requested, starting new process")
+			pid, err := spawnReplacement(listeners, tcpPing)
+			if err != nil {
+				slog.Error("Graceful restart failed, keeping current process", "error", err)
+				continue
+			}
+
+			slog.Info("New process is ready, draining connections", "pid", pid)
+			signal.Stop(signals)
+			stop()
+			return
+		}
+	}()
+}
+
+func spawnReplacement(listeners []net.Listener, tcpPing net.Listener) (int, error) {
+	files := make([]*os.File, 0, len(listeners)+2)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	for _, ln := range listeners {
+		file, err := listenerFile(ln)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, file)
+	}
+	tcpPingFd := 0
+	if tcpPing != nil {
+		file, err := listenerFile(tcpPing)
+		if err != nil {
+			return 0, err
+		}
+		tcpPingFd = 3 + len(files)
+		files = append(files, file)
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyRead.Close()
+	readyFd := 3 + len(files)
+	files = append(files, readyWrite)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	env := make([]string, 0, len(os.Environ())+3)
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if name != listenFdsEnv && name != tcpPingFdEnv && name != readyFdEnv {
+			env = append(env, variable)
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("%s=%d", listenFdsEnv, len(listeners)),
+		fmt.Sprintf("%s=%d", readyFdEnv, readyFd),
+	)
+	if tcpPingFd != 0 {
+		env = append(env, fmt.Sprintf("%s=%d", tcpPingFdEnv, tcpPingFd))
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	// Only the child may hold the write end, so a crash before it is
+	// ready shows up as EOF
+	readyWrite.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyRead.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Wait()
+			return 0, errors.New("new process exited before becoming ready")
+		}
+	case <-time.After(30 * time.Second):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, errors.New("new process did not become ready within 30s")
+	}
+
+	return cmd.Process.Pid, nil
+}
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("cannot hand over %T", ln)
+	}
+	return filer.File()
+}
+
+// signalRestart asks the server running as pid to restart gracefully
+func signalRestart(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
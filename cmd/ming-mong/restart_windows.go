@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"ming-mong/server"
+)
+
+// Graceful restarts rely on fd inheritance and SIGUSR2, neither of which
+// exists on Windows
+
+func inheritedListeners(options server.TCPOptions) ([]net.Listener, error) {
+	return nil, nil
+}
+
+func inheritedTCPPingListener(options server.TCPOptions) (net.Listener, error) {
+	return nil, nil
+}
+
+func notifyReady() {}
+
+func watchRestart(listeners []net.Listener, tcpPing net.Listener, stop context.CancelFunc) {}
+
+func signalRestart(pid int) error {
+	return errors.New("graceful restart is not supported on Windows")
+}
@@ -0,0 +1,250 @@
+//go:build !no_update && !minimal
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"ming-mong/server"
+)
+
+// updatePublicKey is the base64 ed25519 key release checksums are signed
+// with, set at build time with -ldflags "-X main.updatePublicKey=...".
+// Without it updates are verified against checksums.txt only.
+var updatePublicKey = ""
+
+// githubRelease is the part of the GitHub releases API response we use
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+var updateClient = &http.Client{Timeout: 2 * time.Minute}
+
+// runUpdate implements `ming-mong update`: it fetches the latest (or the
+// requested) GitHub release, verifies the binary for this platform against
+// the release checksums and replaces the running executable
+func runUpdate(args []string) {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	repo := flags.String("repo", "suzzukin/ming-mong", "GitHub repository to fetch releases from")
+	tag := flags.String("version", "", "release tag to install (default: latest)")
+	check := flags.Bool("check", false, "only report whether an update is available")
+	force := flags.Bool("force", false, "install even if the version matches")
+	restart := flags.Bool("restart", false, "gracefully restart the running server after updating")
+	pidFile := flags.String("pid-file", os.Getenv("PID_FILE"), "PID file of the running server, for -restart")
+	flags.Parse(args)
+
+	// HTTP_PROXY and HTTPS_PROXY apply as well, through the default
+	// transport
+	if proxy := envProxy("OUTBOUND_PROXY"); proxy != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		updateClient.Transport = transport
+	}
+
+	release, err := fetchRelease(*repo, *tag)
+	if err != nil {
+		fatal("Failed to fetch release", "error", err)
+	}
+
+	if release.TagName == server.Version && !*force {
+		slog.Info("Already running the latest release", "version", server.Version)
+		return
+	}
+	if *check {
+		slog.Info("Update available", "current", server.Version, "latest", release.TagName)
+		return
+	}
+
+	name := fmt.Sprintf("ming-mong_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	binary, err := downloadAsset(release, name)
+	if err != nil {
+		fatal("Failed to download release", "asset", name, "error", err)
+	}
+	checksums, err := downloadAsset(release, "checksums.txt")
+	if err != nil {
+		fatal("Failed to download checksums", "error", err)
+	}
+
+	if updatePublicKey != "" {
+		signature, err := downloadAsset(release, "checksums.txt.sig")
+		if err != nil {
+			fatal("Failed to download checksum signature", "error", err)
+		}
+		if err := verifyChecksumSignature(checksums, signature); err != nil {
+			fatal("Refusing update", "error", err)
+		}
+	}
+	if err := verifyChecksum(binary, checksums, name); err != nil {
+		fatal("Refusing update", "error", err)
+	}
+
+	path, err := replaceExecutable(binary)
+	if err != nil {
+		fatal("Failed to install update", "error", err)
+	}
+	slog.Info("Updated", "path", path, "from", server.Version, "to", release.TagName)
+
+	if *restart {
+		if *pidFile == "" {
+			fatal("-restart needs -pid-file or PID_FILE")
+		}
+		content, err := os.ReadFile(*pidFile)
+		if err != nil {
+			fatal("Failed to read PID file", "error", err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+		if err != nil {
+			fatal("Invalid PID file", "file", *pidFile)
+		}
+		if err := signalRestart(pid); err != nil {
+			fatal("Failed to restart server", "error", err)
+		}
+		slog.Info("Graceful restart requested", "pid", pid)
+	}
+}
+
+func fetchRelease(repo, tag string) (*githubRelease, error) {
+	url := "https://api.github.com/repos/" + repo + "/releases/latest"
+	if tag != "" {
+		url = "https://api.github.com/repos/" + repo + "/releases/tags/" + tag
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+func downloadAsset(release *githubRelease, name string) ([]byte, error) {
+	for _, asset := range release.Assets {
+		if asset.Name != name {
+			continue
+		}
+
+		resp, err := updateClient.Get(asset.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("download returned %s", resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	}
+	return nil, fmt.Errorf("release %s has no asset %s", release.TagName, name)
+}
+
+// verifyChecksum looks name up in a sha256sum style checksums file
+func verifyChecksum(binary, checksums []byte, name string) error {
+	sum := sha256.Sum256(binary)
+	actual := hex.EncodeToString(sum[:])
+
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		if !strings.EqualFold(fields[0], actual) {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("no checksum for %s", name)
+}
+
+func verifyChecksumSignature(checksums, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid built-in update public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return errors.New("malformed checksum signature")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), checksums, sig) {
+		return errors.New("checksum signature does not verify")
+	}
+	return nil
+}
+
+// replaceExecutable swaps the running binary for the new one. The file is
+// written next to the old one and renamed over it, so the swap is atomic
+// and a running server keeps its open copy.
+func replaceExecutable(binary []byte) (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ming-mong-update-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", err
+	}
+
+	// Windows can't replace a running executable, but can rename it away
+	if runtime.GOOS == "windows" {
+		os.Remove(path + ".old")
+		if err := os.Rename(path, path+".old"); err != nil {
+			return "", err
+		}
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
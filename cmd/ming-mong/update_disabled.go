@@ -0,0 +1,9 @@
+//go:build no_update || minimal
+
+package main
+
+// runUpdate is unavailable in builds with the no_update or minimal tag;
+// the binary is replaced through the package manager or image instead
+func runUpdate([]string) {
+	fatal("Self-update not built in (no_update or minimal build tag)")
+}
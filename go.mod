@@ -3,3 +3,11 @@ module ming-mong
 go 1.21
 
 require github.com/gorilla/websocket v1.5.0
+
+require golang.org/x/sys v0.20.0
+
+require (
+	golang.org/x/crypto v0.23.0
+	golang.org/x/net v0.21.0
+	golang.org/x/text v0.15.0 // indirect
+)
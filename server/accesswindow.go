@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccessWindow is a daily UTC time span, optionally on some weekdays only,
+// in which a key or client may ping
+type AccessWindow struct {
+	// Days are the weekdays the window opens on; empty means every day
+	Days []time.Weekday
+	// Start and End are offsets from midnight UTC; End before Start spans
+	// midnight
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseAccessWindows parses comma-separated id=[days/]HH:MM-HH:MM entries,
+// such as "v2=08:00-20:00,kiosk-7=Mon-Fri/07:30-18:00". An ID may be
+// listed several times to get several windows.
+func ParseAccessWindows(value string) (map[string][]AccessWindow, error) {
+	windows := make(map[string][]AccessWindow)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, spec, ok := strings.Cut(entry, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid access window %q: expected id=HH:MM-HH:MM", entry)
+		}
+
+		var window AccessWindow
+		if days, span, ok := strings.Cut(spec, "/"); ok {
+			parsed, err := parseWeekdays(days)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access window %q: %w", entry, err)
+			}
+			window.Days, spec = parsed, span
+		}
+		from, to, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid access window %q: expected HH:MM-HH:MM", entry)
+		}
+		var err error
+		if window.Start, err = parseTimeOfDay(from); err != nil {
+			return nil, fmt.Errorf("invalid access window %q: %w", entry, err)
+		}
+		if window.End, err = parseTimeOfDay(to); err != nil {
+			return nil, fmt.Errorf("invalid access window %q: %w", entry, err)
+		}
+		if window.Start == window.End {
+			return nil, fmt.Errorf("invalid access window %q: empty span", entry)
+		}
+		windows[id] = append(windows[id], window)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay reads HH:MM, allowing 24:00 as the end of the day
+func parseTimeOfDay(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseWeekdays reads "Mon-Fri", "Sat" or "Mon+Wed+Fri"
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(value, "+") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[strings.ToLower(to)]; !ok {
+				return nil, fmt.Errorf("invalid weekday %q", to)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days = append(days, day)
+			if day == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// open reports whether the window covers now. A window spanning midnight
+// belongs to the day it starts on.
+func (w AccessWindow) open(now time.Time) bool {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	offset := now.Sub(midnight)
+	if w.Start < w.End {
+		return offset >= w.Start && offset < w.End && w.onDay(now.Weekday())
+	}
+	// Overnight: the evening part today, or the morning part of the span
+	// that started yesterday
+	if offset >= w.Start {
+		return w.onDay(now.Weekday())
+	}
+	return offset < w.End && w.onDay((now.Weekday()+6)%7)
+}
+
+func (w AccessWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// withinAccessWindow reports whether the key or client a ping was signed
+// with may ping at now; IDs without windows always may
+func (v *signatureVerifier) withinAccessWindow(keyID, clientID string, now time.Time) bool {
+	id := keyID
+	if id == "" {
+		id = clientID
+	}
+	windows, restricted := v.windows[id]
+	if id == "" || !restricted {
+		return true
+	}
+	for _, window := range windows {
+		if window.open(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+//go:build !no_acme && !minimal
+
+package server
+
+import (
+	"crypto/tls"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEConfig obtains and renews certificates for config.ACMEDomains via
+// TLS-ALPN-01 challenges, which the CA sends to port 443 of each domain
+func (s *Server) newACMEConfig(config Config) (*tls.Config, error) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+		Email:      config.ACMEEmail,
+	}
+	if config.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: config.ACMEDirectoryURL}
+	}
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := manager.GetCertificate(hello)
+			// Handshakes for other names are scanners, only failures
+			// for the configured domains affect clients
+			if !slices.Contains(config.ACMEDomains, strings.ToLower(hello.ServerName)) {
+				return cert, err
+			}
+			if err != nil {
+				s.health.set("acme", HealthFailing, "certificate for "+hello.ServerName+": "+err.Error())
+			} else {
+				s.health.clear("acme")
+			}
+			return cert, err
+		},
+		NextProtos: []string{"h2", "http/1.1", acme.ALPNProto},
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build no_acme || minimal
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+func (s *Server) newACMEConfig(Config) (*tls.Config, error) {
+	return nil, errors.New("ACME support not built in (no_acme or minimal build tag)")
+}
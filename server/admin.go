@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// newAdminHandler serves the admin API under /admin/. Requests without a
+// valid bearer token get the same connection drop as unknown paths, so the
+// API is invisible to scanners; valid tokens whose role is too low for an
+// endpoint get 403.
+func (s *Server) newAdminHandler(tokens map[string]AdminRole) http.Handler {
+	mux := http.NewServeMux()
+	route := func(pattern string, role AdminRole, handler http.HandlerFunc) {
+		mux.Handle(pattern, withRole(role, s.allowMethods(handler, http.MethodGet)))
+	}
+	route("/admin/connections", RoleViewer, s.handleAdminConnections)
+	route("/admin/clients", RoleViewer, s.handleAdminClients)
+	route("/admin/tags", RoleViewer, s.handleAdminTags)
+	route("/admin/timings", RoleViewer, s.handleAdminTimings)
+	route("/admin/heatmap", RoleViewer, s.handleAdminHeatmap)
+	route("/admin/mirror", RoleViewer, s.handleAdminMirror)
+	route("/admin/slo", RoleViewer, s.handleAdminSLO)
+	route("/admin/client-keys", RoleViewer, s.handleAdminClientKeys)
+	// Anyone may look; POST needs RoleOperator
+	mux.Handle("/admin/maintenance", s.allowMethods(http.HandlerFunc(s.handleAdminMaintenance), http.MethodGet, http.MethodPost))
+	// Expected signatures are as good as the secret
+	route("/admin/signature", RoleAdmin, s.handleAdminSignature)
+	// Goroutine stacks show internals
+	route("/admin/watchdog", RoleAdmin, s.handleAdminWatchdog)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		supplied := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		role := roleFor(tokens, supplied)
+		if role == 0 {
+			dropConnection(w)
+			return
+		}
+
+		if _, pattern := mux.Handler(r); pattern == "" {
+			dropConnection(w)
+			return
+		}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminRoleKey{}, role)))
+	})
+}
+
+// adminRoleKey carries the caller's role in the request context
+type adminRoleKey struct{}
+
+// withRole only passes requests from role or above on to handler
+func withRole(role AdminRole, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if caller, _ := r.Context().Value(adminRoleKey{}).(AdminRole); caller < role {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden", "required_role": role.String()})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleAdminConnections lists the top talkers:
+// GET /admin/connections?limit=20&sort=live|total
+func (s *Server) handleAdminConnections(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_limit"})
+			return
+		}
+		limit = n
+	}
+
+	byTotal := r.URL.Query().Get("sort") == "total"
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"connections": s.stats.top(limit, byTotal),
+	})
+}
+
+// handleAdminClients breaks requests down by User-Agent and Origin per
+// endpoint: GET /admin/clients?endpoint=/ws&limit=20
+func (s *Server) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_limit"})
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"endpoints": s.clients.breakdown(r.URL.Query().Get("endpoint"), limit),
+	})
+}
+
+// handleAdminTags reports connections and results per connection tag:
+// GET /admin/tags
+func (s *Server) handleAdminTags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"tags": s.tags.snapshot(),
+	})
+}
+
+// handleAdminTimings reports connection stage latency histograms:
+// GET /admin/timings
+func (s *Server) handleAdminTimings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]histogramSnapshot{
+		"tls_handshake": s.timings.tlsHandshake.snapshot(),
+		"upgrade":       s.timings.upgrade.snapshot(),
+		"first_message": s.timings.firstMessage.snapshot(),
+	})
+}
+
+// handleAdminHeatmap reports per-hour latency bucket counts per client:
+// GET /admin/heatmap?hours=24&client=203.0.113.7&limit=20
+func (s *Server) handleAdminHeatmap(w http.ResponseWriter, r *http.Request) {
+	hours := s.config.HeatmapHours
+	if value := r.URL.Query().Get("hours"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_hours"})
+			return
+		}
+		hours = n
+	}
+
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_limit"})
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, s.heatmap.snapshot(s.clock.Now(), hours, r.URL.Query().Get("client"), limit))
+}
+
+// handleAdminSignature explains why a client's signature is rejected:
+// GET /admin/signature?signature=a1b2c3d4e5f67890&key_id=v2
+func (s *Server) handleAdminSignature(w http.ResponseWriter, r *http.Request) {
+	signature := r.URL.Query().Get("signature")
+	if signature == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing_signature"})
+		return
+	}
+	query := r.URL.Query()
+	writeJSON(w, http.StatusOK, s.signatures.Load().explain(query.Get("key_id"), query.Get("client_id"), query.Get("nonce"), signature, s.clock.Now()))
+}
+
+// handleAdminSLO reports SLO compliance and burn rates: GET /admin/slo
+func (s *Server) handleAdminSLO(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.sloStatuses(s.clock.Now()))
+}
+
+// handleAdminClientKeys attributes pings and invalid attempts to client
+// IDs: GET /admin/client-keys
+func (s *Server) handleAdminClientKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"registered": len(s.signatures.Load().clients),
+		"clients":    s.keyStats.snapshot(),
+	})
+}
+
+// handleAdminMirror reports shadow traffic results: GET /admin/mirror
+func (s *Server) handleAdminMirror(w http.ResponseWriter, r *http.Request) {
+	if s.mirror == nil {
+		dropConnection(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.mirror.stats())
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// admissionClass orders requests by how much of Config.MaxConnections
+// they may occupy
+type admissionClass int
+
+const (
+	// classAnonymous is every request not known to be one of the below,
+	// limited to Config.AnonymousShare
+	classAnonymous admissionClass = iota
+	// classPriority are registered clients, which name a known key or
+	// client ID when connecting, and persistent sessions; they may use
+	// every slot
+	classPriority
+)
+
+func (c admissionClass) String() string {
+	if c == classPriority {
+		return "priority"
+	}
+	return "anonymous"
+}
+
+// admissionControl caps concurrent requests once the server is saturated.
+// Anonymous traffic only gets a share of the slots, so a flood of it can't
+// lock out monitoring clients.
+type admissionControl struct {
+	limit          int
+	anonymousLimit int
+
+	mu       sync.Mutex
+	active   [2]int
+	rejected [2]uint64
+}
+
+// newAdmissionControl returns nil, admitting everything, without a limit
+func newAdmissionControl(limit int, anonymousShare float64) *admissionControl {
+	if limit <= 0 {
+		return nil
+	}
+	return &admissionControl{limit: limit, anonymousLimit: int(float64(limit) * anonymousShare)}
+}
+
+// admissionSlot is a request's place in admissionControl, released when the
+// request ends
+type admissionSlot struct {
+	control *admissionControl
+	class   admissionClass
+	// keyID and clientID are the registered IDs the request claimed when
+	// connecting, which its ping must be signed with to keep the slot
+	keyID, clientID string
+}
+
+// admit takes a slot for class, or returns false when the server is full
+// for it
+func (a *admissionControl) admit(class admissionClass) (*admissionSlot, bool) {
+	if a == nil {
+		return nil, true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	total := a.active[classAnonymous] + a.active[classPriority]
+	if total >= a.limit || (class == classAnonymous && a.active[classAnonymous] >= a.anonymousLimit) {
+		a.rejected[class]++
+		return nil, false
+	}
+	a.active[class]++
+	return &admissionSlot{control: a, class: class}, true
+}
+
+// promote moves an admitted request to the priority class, freeing its
+// anonymous slot, once it turns into a persistent session
+func (slot *admissionSlot) promote() {
+	if slot == nil || slot.class == classPriority {
+		return
+	}
+	slot.control.mu.Lock()
+	slot.control.active[slot.class]--
+	slot.control.active[classPriority]++
+	slot.control.mu.Unlock()
+	slot.class = classPriority
+}
+
+// confirm checks the key and client ID a ping was signed with against the
+// ones claimed for a priority slot when connecting. A request that claimed
+// an ID it can't sign for drops to the anonymous class, and confirm fails
+// when that class is full.
+func (slot *admissionSlot) confirm(keyID, clientID string) bool {
+	if slot == nil || slot.class != classPriority || (slot.keyID == "" && slot.clientID == "") {
+		return true
+	}
+	if (slot.keyID != "" && slot.keyID == keyID) || (slot.clientID != "" && slot.clientID == clientID) {
+		return true
+	}
+	slot.control.mu.Lock()
+	defer slot.control.mu.Unlock()
+	if slot.control.active[classAnonymous] >= slot.control.anonymousLimit {
+		return false
+	}
+	slot.control.active[classPriority]--
+	slot.control.active[classAnonymous]++
+	slot.class = classAnonymous
+	return true
+}
+
+func (slot *admissionSlot) release() {
+	if slot == nil {
+		return
+	}
+	slot.control.mu.Lock()
+	slot.control.active[slot.class]--
+	slot.control.mu.Unlock()
+}
+
+// admissionSlotKey carries the request's admissionSlot in its context
+type admissionSlotKey struct{}
+
+func admissionSlotFrom(ctx context.Context) *admissionSlot {
+	slot, _ := ctx.Value(admissionSlotKey{}).(*admissionSlot)
+	return slot
+}
+
+// withAdmission admits requests to handler by class, answering the rest
+// with 503 so load balancers back off instead of seeing a dead node
+func (s *Server) withAdmission(pattern string, handler http.Handler) http.Handler {
+	if s.admission == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := classAnonymous
+		// Registered clients name their key or client ID when
+		// connecting, as the message carrying it is only read after
+		// admission; the handler confirms the claim once it is
+		var keyID, clientID string
+		if pattern == "/ws" {
+			query, verifier := r.URL.Query(), s.signatures.Load()
+			if _, ok := verifier.keys[query.Get("key_id")]; ok {
+				keyID = query.Get("key_id")
+			}
+			if _, ok := verifier.clients[query.Get("client_id")]; ok {
+				clientID = query.Get("client_id")
+			}
+			if keyID != "" || clientID != "" {
+				class = classPriority
+			}
+		}
+
+		slot, ok := s.admission.admit(class)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(1))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer slot.release()
+		slot.keyID, slot.clientID = keyID, clientID
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), admissionSlotKey{}, slot)))
+	})
+}
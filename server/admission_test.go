@@ -0,0 +1,64 @@
+package server
+
+import "testing"
+
+func TestAdmissionClasses(t *testing.T) {
+	control := newAdmissionControl(4, 0.5)
+
+	var anonymous []*admissionSlot
+	for i := 0; i < 2; i++ {
+		slot, ok := control.admit(classAnonymous)
+		if !ok {
+			t.Fatalf("anonymous request %d rejected", i)
+		}
+		anonymous = append(anonymous, slot)
+	}
+	if _, ok := control.admit(classAnonymous); ok {
+		t.Fatal("anonymous request admitted beyond its share")
+	}
+
+	// Priority requests may use the remaining slots, but no more
+	priority, ok := control.admit(classPriority)
+	if !ok {
+		t.Fatal("priority request rejected")
+	}
+	if _, ok := control.admit(classPriority); !ok {
+		t.Fatal("priority request rejected with a slot left")
+	}
+	if _, ok := control.admit(classPriority); ok {
+		t.Fatal("priority request admitted beyond the limit")
+	}
+	if control.rejected[classAnonymous] != 1 || control.rejected[classPriority] != 1 {
+		t.Fatalf("rejected = %v, want one per class", control.rejected)
+	}
+
+	// Claiming a key the ping isn't signed with demotes the slot, which
+	// fails while the anonymous share is taken
+	priority.keyID = "v2"
+	if !priority.confirm("v2", "") {
+		t.Fatal("ping signed with the claimed key refused")
+	}
+	if priority.confirm("v1", "") {
+		t.Fatal("ping signed with another key kept its slot with the anonymous share full")
+	}
+	anonymous[0].release()
+	if !priority.confirm("v1", "") || priority.class != classAnonymous {
+		t.Fatal("ping signed with another key wasn't demoted")
+	}
+	if control.active != [2]int{2, 1} {
+		t.Fatalf("active = %v, want [2 1]", control.active)
+	}
+}
+
+func TestAdmissionPromote(t *testing.T) {
+	control := newAdmissionControl(2, 0.5)
+	slot, _ := control.admit(classAnonymous)
+	slot.promote()
+	if _, ok := control.admit(classAnonymous); !ok {
+		t.Fatal("anonymous share not freed by the promotion")
+	}
+	slot.release()
+	if control.active != [2]int{1, 0} {
+		t.Fatalf("active = %v, want [1 0]", control.active)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ALPNPingProtocol is the ALPN name of the line-based ping protocol: each
+// line is a PingMessage and is answered with one line, without HTTP or
+// WebSocket framing
+const ALPNPingProtocol = "ming-mong/1"
+
+// HandleALPN routes TLS connections that negotiate protocol to handler
+// instead of the HTTP endpoints, so other protocols can share the TLS
+// port. It must be called before Serve; without TLS it does nothing. The
+// connection is closed when handler returns, and ctx ends when the server
+// shuts down.
+func (s *Server) HandleALPN(protocol string, handler func(ctx context.Context, conn *tls.Conn)) {
+	if s.tlsConfig == nil {
+		return
+	}
+	if s.alpn == nil {
+		s.alpn = make(map[string]func(context.Context, *tls.Conn))
+	}
+	if _, exists := s.alpn[protocol]; !exists {
+		s.tlsConfig.NextProtos = append(s.tlsConfig.NextProtos, protocol)
+	}
+	s.alpn[protocol] = handler
+}
+
+// lineReplies writes each reply as a line
+type lineReplies struct {
+	conn net.Conn
+}
+
+func (l lineReplies) WriteMessage(_ int, data []byte) error {
+	l.conn.SetWriteDeadline(time.Now().Add(keepAliveWriteTimeout))
+	_, err := l.conn.Write(append(data, '\n'))
+	return err
+}
+
+// handleALPNPing serves the line-based ping protocol on TLS connections
+func (s *Server) handleALPNPing(ctx context.Context, conn *tls.Conn) {
+	state := conn.ConnectionState()
+	s.serveLinePings(ctx, conn, &http.Request{
+		Method:     "PING",
+		URL:        &url.URL{Path: ALPNPingProtocol},
+		Proto:      ALPNPingProtocol,
+		Header:     http.Header{},
+		RemoteAddr: conn.RemoteAddr().String(),
+		TLS:        &state,
+	})
+}
+
+// serveLinePings answers the pings of the line-based protocol on conn,
+// described by r for logs, samples and the checks shared with HTTP.
+// Connections stay open for further pings until the client is idle for
+// Config.IdleTimeout, an error is sent or a shutdown starts.
+func (s *Server) serveLinePings(ctx context.Context, conn net.Conn, r *http.Request) {
+	if !s.peerPermitted(r) {
+		return
+	}
+	if s.bans != nil && s.bans.banned(s.limitIP(r), s.clock.Now()) {
+		return
+	}
+	clientIP := clientIPFromRequest(r)
+
+	s.stats.opened(clientIP)
+	defer s.stats.closed(clientIP)
+	stop := context.AfterFunc(s.drainCtx, func() { conn.Close() })
+	defer stop()
+
+	replies := replyWriter(lineReplies{conn: conn})
+	reader := bufio.NewReaderSize(conn, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.config.IdleTimeout))
+		line, err := readLine(reader, s.config.MaxMessageSize)
+		if errors.Is(err, ErrOversizedMessage) {
+			s.sendError(replies, err)
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		// Every ping counts against the rate limit, not just the first
+		start := time.Now()
+		startedAt := s.clock.Now()
+		task := s.watchdog.begin(r.Proto, clientIP)
+		reply := replies
+		err = s.checkRateLimit(ctx, s.limitIP(r))
+		if err == nil && s.sealer != nil {
+			line, reply, err = s.sealer.open(replies, line)
+		}
+		var pingMsg PingMessage
+		if err == nil {
+			pingMsg, err = parsePing(line)
+		}
+		if err == nil {
+			err = s.validatePing(ctx, r, clientIP, "", pingMsg)
+		}
+		// Probes measure WebSocket frames, which this protocol doesn't have
+		if err == nil && pingMsg.Type == "probe" {
+			err = fmt.Errorf("%w: %q over %s", ErrInvalidType, pingMsg.Type, r.Proto)
+		}
+
+		result := "ok"
+		if err != nil {
+			result = errorCode(err)
+			s.sendError(reply, err)
+		} else if pingMsg.Type == "time" {
+			result = "time"
+			sendTime(reply, pingMsg, start)
+		} else {
+			s.sendPong(reply, r, clientIP, pingMsg)
+		}
+
+		latency := time.Since(start)
+		logExchange(r, clientIP, "", result, latency, err)
+		s.tags.record("", result)
+		s.recordSample(PingSample{
+			Client:    r.Proto,
+			IP:        clientIP,
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+			Result:    result,
+			Timestamp: startedAt,
+		})
+		task.done()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readLine reads up to a newline, buffering at most limit bytes
+func readLine(reader *bufio.Reader, limit int64) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if int64(len(line)) > limit+1 {
+			return nil, ErrOversizedMessage
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSpace(line), nil
+	}
+}
+
+// routeALPN hands connections negotiating one of the HandleALPN protocols
+// to their handler. Setting TLSNextProto turns off the automatic HTTP/2
+// support of net/http, so HTTP/2 is configured explicitly.
+func (s *Server) routeALPN(httpServer *http.Server) error {
+	if len(s.alpn) == 0 {
+		return nil
+	}
+	httpServer.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	for protocol, handler := range s.alpn {
+		handler := handler
+		httpServer.TLSNextProto[protocol] = func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+			s.activeConns.Add(1)
+			defer s.activeConns.Done()
+			defer s.reportPanic("alpn " + protocol)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stop := context.AfterFunc(s.connCtx, func() {
+				cancel()
+				conn.Close()
+			})
+			defer stop()
+
+			handler(ctx, conn)
+		}
+	}
+	return configureHTTP2(httpServer)
+}
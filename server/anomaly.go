@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// authAnomalyDetector compares the invalid signature count of each interval
+// against a rolling baseline (exponentially weighted moving average) and
+// raises an alert when the count jumps well above it, e.g. at the start of
+// a scanning campaign or after a broken client rollout
+type authAnomalyDetector struct {
+	health   *healthRegistry
+	interval time.Duration
+	// factor is how many times the baseline a count must reach to alert
+	factor float64
+	// minimum avoids alerting on tiny absolute numbers
+	minimum uint64
+	// inMaintenance holds off alerts while failures are expected
+	inMaintenance func() bool
+
+	failures uint64
+	baseline float64
+	alerting bool
+}
+
+// baselineWeight is the EWMA weight of the newest interval
+const baselineWeight = 0.1
+
+func newAuthAnomalyDetector(health *healthRegistry, interval time.Duration, factor float64, minimum uint64,
+	inMaintenance func() bool) *authAnomalyDetector {
+	return &authAnomalyDetector{health: health, interval: interval, factor: factor, minimum: minimum, inMaintenance: inMaintenance}
+}
+
+func (d *authAnomalyDetector) Record(sample PingSample) {
+	if sample.Result == "invalid_signature" {
+		atomic.AddUint64(&d.failures, 1)
+	}
+}
+
+func (d *authAnomalyDetector) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Maintenance traffic neither alerts nor feeds the baseline
+			count := atomic.SwapUint64(&d.failures, 0)
+			if !d.inMaintenance() {
+				d.evaluate(count)
+			}
+		}
+	}
+}
+
+func (d *authAnomalyDetector) evaluate(count uint64) {
+	threshold := d.baseline * d.factor
+	anomalous := count >= d.minimum && float64(count) > threshold
+
+	switch {
+	case anomalous && !d.alerting:
+		d.alerting = true
+		slog.Warn("Invalid signature anomaly", "failures", count, "interval", d.interval, "baseline", d.baseline)
+		d.health.set("auth_anomaly", HealthDegraded, "invalid signature rate far above baseline")
+	case !anomalous && d.alerting:
+		d.alerting = false
+		slog.Info("Invalid signature rate back to normal", "failures", count, "interval", d.interval, "baseline", d.baseline)
+		d.health.clear("auth_anomaly")
+	}
+
+	// Anomalous intervals don't feed the baseline, otherwise a sustained
+	// attack would soon look normal
+	if !anomalous {
+		d.baseline = (1-baselineWeight)*d.baseline + baselineWeight*float64(count)
+	}
+}
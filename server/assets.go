@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+)
+
+//go:embed assets
+var assetFS embed.FS
+
+// asset is an embedded file served with caching headers
+type asset struct {
+	name        string
+	content     []byte
+	contentType string
+	etag        string
+}
+
+func loadAsset(name string) *asset {
+	content, err := assetFS.ReadFile("assets/" + name)
+	if err != nil {
+		// Embedded assets are part of the binary, a missing one is a build bug
+		panic(err)
+	}
+
+	hash := sha256.Sum256(content)
+	return &asset{
+		name:        name,
+		content:     content,
+		contentType: mime.TypeByExtension(path.Ext(name)),
+		etag:        `"` + hex.EncodeToString(hash[:8]) + `"`,
+	}
+}
+
+// ServeHTTP answers with the asset, honouring If-None-Match so browsers
+// can revalidate cheaply once max-age expires
+func (a *asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.serve(w, r, "public, max-age=3600")
+}
+
+func (a *asset) serve(w http.ResponseWriter, r *http.Request, cacheControl string) {
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", a.etag)
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(a.content))
+}
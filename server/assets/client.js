@@ -0,0 +1,101 @@
+// Ming-Mong browser client
+//
+// Usage:
+//   <script src="https://your-server:8443/client.js"></script>
+//   MingMong.ping('wss://your-server:8443/ws').then(pong => console.log(pong));
+//   MingMong.ping(url, { keyId: 'v2', secret: '...' }) for a named secret
+//   MingMong.ping(url, { secret: '...' }) for a server with MING_MONG_SECRET
+//   MingMong.ping(url, { clientId: 'kiosk-7', secret: '...' }) for a client key
+//   MingMong.ping(url, { nonce: true }) signs a random nonce against replays
+(function (global) {
+    'use strict';
+
+    function toHex(buffer) {
+        return Array.from(new Uint8Array(buffer))
+            .map(b => b.toString(16).padStart(2, '0'))
+            .join('');
+    }
+
+    // SHA256(date + nonce + secret)[:16] with date as UTC YYYY-MM-DD, the
+    // nonce if any and the built-in "ming-mong-server" secret by default
+    function signature(date, secret, nonce) {
+        const day = (date || new Date()).toISOString().split('T')[0];
+        const data = new TextEncoder().encode(day + (nonce || '') + (secret || 'ming-mong-server'));
+        return crypto.subtle.digest('SHA-256', data).then(hash => toHex(hash).slice(0, 16));
+    }
+
+    // HMAC-SHA256(secret, date + nonce)[:16], for unkeyed pings to servers
+    // with MING_MONG_SECRET set
+    function hmacSignature(date, secret, nonce) {
+        const day = (date || new Date()).toISOString().split('T')[0];
+        const encoder = new TextEncoder();
+        return crypto.subtle.importKey('raw', encoder.encode(secret), { name: 'HMAC', hash: 'SHA-256' }, false, ['sign'])
+            .then(key => crypto.subtle.sign('HMAC', key, encoder.encode(day + (nonce || ''))))
+            .then(mac => toHex(mac).slice(0, 16));
+    }
+
+    // Resolves with the pong message extended with rtt_ms, rejects on
+    // server errors, connection failures and timeouts
+    function ping(url, options) {
+        const timeout = (options && options.timeout) || 5000;
+
+        const keyId = options && options.keyId;
+        const clientId = options && options.clientId;
+        const secret = options && options.secret;
+        const nonce = options && options.nonce ? toHex(crypto.getRandomValues(new Uint8Array(16))) : '';
+        const signed = secret && !keyId ? hmacSignature(null, secret, nonce) : signature(null, secret, nonce);
+
+        return signed.then(sig => new Promise((resolve, reject) => {
+            // Naming the key lets a saturated server admit the ping ahead
+            // of anonymous traffic
+            const separator = url.includes('?') ? '&' : '?';
+            const ws = new WebSocket(keyId ? url + separator + 'key_id=' + encodeURIComponent(keyId)
+                : clientId ? url + separator + 'client_id=' + encodeURIComponent(clientId) : url);
+            let sentAt = 0;
+
+            const timer = setTimeout(() => {
+                ws.close();
+                reject(new Error('timeout'));
+            }, timeout);
+
+            ws.onopen = () => {
+                sentAt = performance.now();
+                const message = {
+                    type: 'ping',
+                    signature: sig,
+                    timestamp: new Date().toISOString()
+                };
+                if (keyId) {
+                    message.key_id = keyId;
+                }
+                if (clientId) {
+                    message.client_id = clientId;
+                }
+                if (nonce) {
+                    message.nonce = nonce;
+                }
+                ws.send(JSON.stringify(message));
+            };
+
+            ws.onmessage = (event) => {
+                clearTimeout(timer);
+                ws.close();
+
+                const response = JSON.parse(event.data);
+                if (response.type === 'pong') {
+                    response.rtt_ms = performance.now() - sentAt;
+                    resolve(response);
+                } else {
+                    reject(new Error(response.error || 'unexpected_response'));
+                }
+            };
+
+            ws.onerror = () => {
+                clearTimeout(timer);
+                reject(new Error('connection_failed'));
+            };
+        }));
+    }
+
+    global.MingMong = { ping: ping, signature: signature, hmacSignature: hmacSignature };
+})(window);
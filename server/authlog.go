@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// authFailureLog appends one line per invalid signature or message type,
+// in a format that stays the same across releases so fail2ban and similar
+// tools can match it:
+//
+//	2024-01-15T10:31:02Z ming-mong auth_failure ip=203.0.113.7 code=invalid_signature endpoint=/ws
+//
+// The file is reopened on Reload, so it can be rotated by renaming it.
+type authFailureLog struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newAuthFailureLog(path string) (*authFailureLog, error) {
+	l := &authFailureLog{path: path}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reopen switches to a fresh handle on the log path
+func (l *authFailureLog) reopen() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("open auth failure log: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	return nil
+}
+
+// write records a failure from clientIP. IPs that don't parse, such as a
+// bad header from a trusted proxy, are skipped so they can't inject lines.
+func (l *authFailureLog) write(now time.Time, clientIP, code, endpoint string) {
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return
+	}
+	line := fmt.Sprintf("%s ming-mong auth_failure ip=%s code=%s endpoint=%s\n",
+		now.UTC().Format(time.RFC3339), addr.WithZone(""), code, endpoint)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.WriteString(line); err != nil {
+		slog.Warn("Failed to write auth failure log", "file", l.path, "error", err)
+	}
+}
+
+func (l *authFailureLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file.Close()
+}
+
+// recordAuthFailure counts err against the client of r when the ping was
+// rejected as invalid_signature or invalid_type, for the auth failure log
+// and bans
+func (s *Server) recordAuthFailure(r *http.Request, err error) {
+	signature := errors.Is(err, ErrInvalidSignature)
+	if !signature && !errors.Is(err, ErrInvalidType) {
+		return
+	}
+	// Bans and the log go by the same address as rate limits, so forged
+	// proxy headers can neither dodge them nor point them at an innocent
+	// address
+	clientIP := s.limitIP(r)
+	if s.authLog != nil {
+		s.authLog.write(s.clock.Now(), clientIP, errorCode(err), r.URL.Path)
+	}
+	if signature && s.bans != nil && s.bans.failed(clientIP, s.clock.Now()) {
+		slog.Warn("Banning client IP after repeated invalid signatures",
+			"client_ip", clientIP, "failures", s.bans.threshold, "duration", s.bans.duration)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// banList counts invalid signatures per client IP and bans an IP for a
+// cooldown once threshold of them arrive within window. At most maxIPs are
+// tracked; beyond that new IPs aren't counted until entries expire.
+type banList struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+	maxIPs    int
+
+	mu      sync.Mutex
+	entries map[string]*banEntry
+	total   int64
+}
+
+type banEntry struct {
+	failures int
+	since    time.Time
+	until    time.Time
+}
+
+// newBanList returns nil, banning nobody, when threshold is not positive
+func newBanList(threshold int, window, duration time.Duration, maxIPs int) *banList {
+	if threshold <= 0 {
+		return nil
+	}
+	return &banList{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		maxIPs:    maxIPs,
+		entries:   make(map[string]*banEntry),
+	}
+}
+
+// banned reports whether clientIP is serving a ban at now
+func (b *banList) banned(clientIP string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry := b.entries[clientIP]
+	return entry != nil && now.Before(entry.until)
+}
+
+// failed records an invalid signature from clientIP and reports whether it
+// started a ban
+func (b *banList) failed(clientIP string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.entries[clientIP]
+	if entry == nil {
+		if len(b.entries) >= b.maxIPs {
+			b.prune(now)
+			if len(b.entries) >= b.maxIPs {
+				return false
+			}
+		}
+		entry = &banEntry{since: now}
+		b.entries[clientIP] = entry
+	}
+	if now.Sub(entry.since) > b.window {
+		entry.failures, entry.since = 0, now
+	}
+	entry.failures++
+	if entry.failures < b.threshold || now.Before(entry.until) {
+		return false
+	}
+	entry.failures, entry.since, entry.until = 0, now, now.Add(b.duration)
+	b.total++
+	return true
+}
+
+// prune forgets IPs that are neither banned nor inside a counting window
+func (b *banList) prune(now time.Time) {
+	for ip, entry := range b.entries {
+		if !now.Before(entry.until) && now.Sub(entry.since) > b.window {
+			delete(b.entries, ip)
+		}
+	}
+}
+
+// active returns the number of IPs currently banned
+func (b *banList) active(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, entry := range b.entries {
+		if now.Before(entry.until) {
+			n++
+		}
+	}
+	return n
+}
+
+// withBans drops every request from a banned client IP, by limitIP,
+// without a response, so scanners can't tell the server apart from a dead
+// host
+func (s *Server) withBans(handler http.Handler) http.Handler {
+	if s.bans == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.bans.banned(s.limitIP(r), s.clock.Now()) {
+			dropConnection(w)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	bans := newBanList(3, time.Minute, time.Hour, 2)
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	// Failures spread wider than the window don't add up
+	bans.failed("192.0.2.1", now)
+	bans.failed("192.0.2.1", now.Add(30*time.Second))
+	if bans.failed("192.0.2.1", now.Add(2*time.Minute)) {
+		t.Fatal("banned for failures outside one window")
+	}
+
+	now = now.Add(2 * time.Minute)
+	bans.failed("192.0.2.1", now)
+	if !bans.failed("192.0.2.1", now) {
+		t.Fatal("third failure within the window didn't ban")
+	}
+	if !bans.banned("192.0.2.1", now.Add(59*time.Minute)) || bans.banned("192.0.2.2", now) {
+		t.Fatal("ban not applied to exactly the failing IP")
+	}
+	if bans.active(now) != 1 || bans.total != 1 {
+		t.Fatalf("active = %d, total = %d, want 1 and 1", bans.active(now), bans.total)
+	}
+	if bans.banned("192.0.2.1", now.Add(time.Hour)) {
+		t.Fatal("ban outlasted its duration")
+	}
+
+	// Beyond maxIPs new IPs aren't counted until entries expire
+	bans.failed("192.0.2.2", now)
+	for i := 0; i < 3; i++ {
+		if bans.failed("192.0.2.3", now) {
+			t.Fatal("untracked IP banned")
+		}
+	}
+	later := now.Add(2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		bans.failed("192.0.2.3", later)
+	}
+	if !bans.banned("192.0.2.3", later) {
+		t.Fatal("IP not tracked after the others expired")
+	}
+}
+
+func TestBanListDisabled(t *testing.T) {
+	if newBanList(0, time.Minute, time.Hour, 10) != nil {
+		t.Fatal("ban list without a threshold")
+	}
+}
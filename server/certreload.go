@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves the certificate from certFile/keyFile and picks up
+// changes to either file, including the atomic symlink swap Kubernetes
+// performs on mounted secrets
+type certReloader struct {
+	certFile string
+	keyFile  string
+	health   *healthRegistry
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	fingerprint [sha256.Size]byte
+}
+
+func newCertReloader(certFile, keyFile string, health *healthRegistry) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, health: health}
+	if _, err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// reload re-reads both files and swaps the certificate when their content
+// changed; a broken pair keeps the previous certificate in service
+func (c *certReloader) reload() (bool, error) {
+	certPEM, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	fingerprint := sha256.Sum256(bytes.Join([][]byte{certPEM, keyPEM}, nil))
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && fingerprint == c.fingerprint
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.fingerprint = fingerprint
+	c.mu.Unlock()
+	return true, nil
+}
+
+// watch polls the files every interval until ctx is cancelled; polling
+// rather than inotify keeps working across symlink swaps and network
+// filesystems
+func (c *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := c.reload()
+		if err != nil {
+			slog.Error("TLS certificate reload failed, keeping current certificate", "error", err)
+			c.health.set("tls_reload", HealthDegraded, "certificate reload failed")
+			continue
+		}
+		c.health.clear("tls_reload")
+		if changed {
+			slog.Info("TLS certificate reloaded", "file", c.certFile)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+//go:build !no_clickhouse && !minimal
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// clickHouseSink batches ping samples and inserts them through the
+// ClickHouse HTTP interface using the JSONEachRow format.
+//
+// Expected table schema:
+//
+//	CREATE TABLE ming_mong_pings (
+//	    client     String,
+//	    ip         String,
+//	    latency_ms Float64,
+//	    result     LowCardinality(String),
+//	    ts         DateTime64(3, 'UTC')
+//	) ENGINE = MergeTree ORDER BY (ts, ip)
+type clickHouseSink struct {
+	endpoint      string
+	batchSize     int
+	flushInterval time.Duration
+	samples       chan PingSample
+	client        *http.Client
+	health        *healthRegistry
+	dropped       droppedSamples
+}
+
+type clickHouseRow struct {
+	Client    string  `json:"client"`
+	IP        string  `json:"ip"`
+	LatencyMs float64 `json:"latency_ms"`
+	Result    string  `json:"result"`
+	Timestamp string  `json:"ts"`
+}
+
+func newClickHouseSink(rawURL, table string, batchSize int, flushInterval time.Duration,
+	proxy func(*http.Request) (*url.URL, error), dialer Dialer, health *healthRegistry) (*clickHouseSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ClickHouse URL: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	u.RawQuery = query.Encode()
+
+	return &clickHouseSink{
+		endpoint:      u.String(),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		samples:       make(chan PingSample, batchSize*4),
+		client:        &http.Client{Timeout: 30 * time.Second, Transport: proxiedTransport(proxy, dialer)},
+		health:        health,
+	}, nil
+}
+
+// Record queues a sample without blocking the ping handler; samples are
+// dropped when the queue is full and counted for run to log.
+func (s *clickHouseSink) Record(sample PingSample) {
+	select {
+	case s.samples <- sample:
+	default:
+		s.dropped.add()
+	}
+}
+
+// run inserts batches until ctx is cancelled, then flushes what is queued
+func (s *clickHouseSink) run(ctx context.Context) {
+	go s.dropped.reportEvery(ctx, "ClickHouse queue full, dropped samples")
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]PingSample, 0, s.batchSize)
+	for {
+		select {
+		case sample := <-s.samples:
+			batch = append(batch, sample)
+			if len(batch) >= s.batchSize {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ctx.Done():
+			for len(s.samples) > 0 {
+				batch = append(batch, <-s.samples)
+			}
+			if len(batch) > 0 {
+				s.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+func (s *clickHouseSink) flush(batch []PingSample) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, sample := range batch {
+		encoder.Encode(clickHouseRow{
+			Client:    sample.Client,
+			IP:        sample.IP,
+			LatencyMs: sample.LatencyMs,
+			Result:    sample.Result,
+			Timestamp: sample.Timestamp.UTC().Format("2006-01-02 15:04:05.000"),
+		})
+	}
+
+	resp, err := s.client.Post(s.endpoint, "application/x-ndjson", &body)
+	if err != nil {
+		slog.Error("ClickHouse insert failed", "samples", len(batch), "error", err)
+		s.health.set("clickhouse", HealthDegraded, "insert failed")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		slog.Error("ClickHouse insert failed", "samples", len(batch), "status", resp.Status, "error", string(bytes.TrimSpace(message)))
+		s.health.set("clickhouse", HealthDegraded, "insert failed: "+resp.Status)
+		return
+	}
+	s.health.clear("clickhouse")
+}
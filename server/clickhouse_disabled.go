@@ -0,0 +1,22 @@
+//go:build no_clickhouse || minimal
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type clickHouseSink struct{}
+
+func newClickHouseSink(string, string, int, time.Duration,
+	func(*http.Request) (*url.URL, error), Dialer, *healthRegistry) (*clickHouseSink, error) {
+	return nil, errors.New("ClickHouse support not built in (no_clickhouse or minimal build tag)")
+}
+
+func (*clickHouseSink) Record(PingSample) {}
+
+func (*clickHouseSink) run(context.Context) {}
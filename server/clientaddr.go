@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientAddr returns the caller's address, preferring X-Real-IP, then the
+// first X-Forwarded-For entry, then the socket address. IPv4-mapped IPv6
+// addresses are unmapped and IPv6 zones are kept; the result is invalid when
+// none of the sources parse
+func ClientAddr(r *http.Request) netip.Addr {
+	if value := r.Header.Get("X-Real-IP"); value != "" {
+		return parseClientAddr(value)
+	}
+	if value := r.Header.Get("X-Forwarded-For"); value != "" {
+		first, _, _ := strings.Cut(value, ",")
+		return parseClientAddr(first)
+	}
+	return socketAddr(r)
+}
+
+// socketAddr parses the address of the connection itself, ignoring any proxy
+// headers
+func socketAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return parseClientAddr(host)
+}
+
+// parseClientAddr accepts a bare address, a bracketed IPv6 literal or either
+// with a port, as proxies differ in what they put in forwarding headers
+func parseClientAddr(value string) netip.Addr {
+	value = strings.TrimSpace(value)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// clientIPFromRequest is the textual form of ClientAddr used for logging
+// and stats; unparseable values are passed through so they still show up
+// in logs. Rate limits and bans use limitIP, which clients can't forge.
+func clientIPFromRequest(r *http.Request) string {
+	if addr := ClientAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+	if value := r.Header.Get("X-Real-IP"); value != "" {
+		return strings.TrimSpace(value)
+	}
+	if value := r.Header.Get("X-Forwarded-For"); value != "" {
+		first, _, _ := strings.Cut(value, ",")
+		return strings.TrimSpace(first)
+	}
+	return r.RemoteAddr
+}
+
+// limitIP is the client IP that rate limits go by: the forwarded address
+// when the connection comes from one of Config.TrustedProxies, the socket
+// address otherwise, so rotating forged headers doesn't dodge them
+func (s *Server) limitIP(r *http.Request) string {
+	addr := socketAddr(r)
+	if containsAddr(s.config.TrustedProxies, addr) {
+		return clientIPFromRequest(r)
+	}
+	if addr.IsValid() {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLimitIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	s := &Server{config: Config{TrustedProxies: []*net.IPNet{proxies}}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:40000", "", "", "203.0.113.7"},
+		{"forged X-Forwarded-For", "203.0.113.7:40000", "", "198.51.100.1", "203.0.113.7"},
+		{"forged X-Real-IP", "203.0.113.7:40000", "198.51.100.1", "", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:40000", "", "198.51.100.1, 10.1.2.3", "198.51.100.1"},
+		{"trusted proxy with X-Real-IP", "10.1.2.3:40000", "198.51.100.2", "198.51.100.1", "198.51.100.2"},
+		{"trusted proxy without headers", "10.1.2.3:40000", "", "", "10.1.2.3"},
+		{"IPv4-mapped socket address", "[::ffff:203.0.113.7]:40000", "", "198.51.100.1", "203.0.113.7"},
+		{"IPv6 socket address", "[2001:db8::1]:40000", "", "", "2001:db8::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.RemoteAddr = test.remoteAddr
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if got := s.limitIP(r); got != test.want {
+				t.Fatalf("limitIP = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientUnknown collects attempts with client IDs missing from the
+// registry, so made-up IDs can't grow the statistics
+const clientUnknown = "(unknown)"
+
+// ParseClientKeysFile reads a client key registry: one client_id:secret
+// per line, with blank lines and lines starting with '#' ignored. Removing
+// a line and reloading revokes the client.
+func ParseClientKeysFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		clientID, secret, ok := strings.Cut(line, ":")
+		clientID, secret = strings.TrimSpace(clientID), strings.TrimSpace(secret)
+		if !ok || clientID == "" || secret == "" {
+			return nil, fmt.Errorf("line %d: expected client_id:secret", i+1)
+		}
+		if _, exists := keys[clientID]; exists {
+			return nil, fmt.Errorf("line %d: duplicate client ID %q", i+1, clientID)
+		}
+		keys[clientID] = secret
+	}
+	return keys, nil
+}
+
+// clientKeyCount is the signing history of one client ID
+type clientKeyCount struct {
+	ClientID string    `json:"client_id"`
+	Valid    uint64    `json:"valid"`
+	Invalid  uint64    `json:"invalid"`
+	LastSeen time.Time `json:"last_seen"`
+	LastIP   string    `json:"last_ip"`
+	// LastError is the error code of the latest rejected attempt
+	LastError string `json:"last_error,omitempty"`
+}
+
+// clientKeyStats attributes pings, and above all invalid attempts, to the
+// client IDs they claim
+type clientKeyStats struct {
+	mu      sync.Mutex
+	entries map[string]*clientKeyCount
+}
+
+func newClientKeyStats() *clientKeyStats {
+	return &clientKeyStats{entries: make(map[string]*clientKeyCount)}
+}
+
+// record counts a ping claiming clientID; known tells whether the ID is in
+// the registry
+func (c *clientKeyStats) record(clientID string, known bool, clientIP string, err error, now time.Time) {
+	if !known {
+		clientID = clientUnknown
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[clientID]
+	if !ok {
+		entry = &clientKeyCount{ClientID: clientID}
+		c.entries[clientID] = entry
+	}
+	if err != nil {
+		entry.Invalid++
+		entry.LastError = errorCode(err)
+	} else {
+		entry.Valid++
+	}
+	entry.LastSeen = now
+	entry.LastIP = clientIP
+}
+
+// snapshot returns the clients with the most invalid attempts first
+func (c *clientKeyStats) snapshot() []clientKeyCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counts := make([]clientKeyCount, 0, len(c.entries))
+	for _, entry := range c.entries {
+		counts = append(counts, *entry)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Invalid != counts[j].Invalid {
+			return counts[i].Invalid > counts[j].Invalid
+		}
+		return counts[i].ClientID < counts[j].ClientID
+	})
+	return counts
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the server what time it is for everything clients and
+// operators can observe: which signature days are accepted, nonce expiry,
+// access windows, bans, maintenance, rate limit windows of a
+// MemoryRateLimiter, uptime, the timestamps of pongs, errors, probes and
+// conformance reports, samples with the heatmap and SLOs built from them,
+// per-IP stats, events and the auth failure log. Connection deadlines, keep-alives and the time service
+// always follow the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock used unless Config.Clock is set
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// FakeClock is a Clock that only moves when told to, so tests can cross a
+// UTC midnight or a ban expiry without waiting for it
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a clock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to now, backwards if need be
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d and returns the new time
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
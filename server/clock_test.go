@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"ming-mong/client"
+	"ming-mong/server"
+)
+
+// serveInMemory runs a server with config on a PipeListener until the test
+// ends
+func serveInMemory(t *testing.T, config server.Config) *server.PipeListener {
+	t.Helper()
+	srv, err := server.New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ln := server.NewPipeListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ln
+}
+
+func expectServerError(t *testing.T, err error, code string) {
+	t.Helper()
+	var serverErr *client.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != code {
+		t.Fatalf("got error %v, want %s", err, code)
+	}
+}
+
+func TestFakeClockSignatureRollover(t *testing.T) {
+	// The client signs with the system clock, so the server starts there
+	clock := server.NewFakeClock(time.Now())
+	config := server.DefaultConfig()
+	config.Clock = clock
+	ln := serveInMemory(t, config)
+
+	ctx := context.Background()
+	opts := client.Options{NetDialContext: ln.DialContext}
+	result, err := client.Ping(ctx, "ws://ming-mong.test/ws", opts)
+	if err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if result.Status != "ok" {
+		t.Fatalf("got status %q, want ok", result.Status)
+	}
+
+	// Two days on, today's signature is no longer among the accepted days
+	clock.Advance(48 * time.Hour)
+	_, err = client.Ping(ctx, "ws://ming-mong.test/ws", opts)
+	expectServerError(t, err, "invalid_signature")
+}
+
+func TestFakeClockBanExpiry(t *testing.T) {
+	clock := server.NewFakeClock(time.Now())
+	config := server.DefaultConfig()
+	config.Clock = clock
+	config.BanThreshold = 1
+	config.BanWindow = time.Minute
+	config.BanDuration = time.Hour
+	ln := serveInMemory(t, config)
+
+	ctx := context.Background()
+	banned := netip.MustParseAddrPort("192.0.2.7:40000")
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return ln.DialFrom(ctx, banned)
+	}
+	_, err := client.Ping(ctx, "ws://ming-mong.test/ws", client.Options{NetDialContext: dial, Secret: "wrong"})
+	expectServerError(t, err, "invalid_signature")
+
+	// Banned clients get their connection dropped; others are unaffected
+	if _, err := client.Ping(ctx, "ws://ming-mong.test/ws", client.Options{NetDialContext: dial}); err == nil {
+		t.Fatal("banned client got a pong")
+	}
+	if _, err := client.Ping(ctx, "ws://ming-mong.test/ws", client.Options{NetDialContext: ln.DialContext}); err != nil {
+		t.Fatalf("Ping from another address: %v", err)
+	}
+
+	clock.Advance(time.Hour)
+	if _, err := client.Ping(ctx, "ws://ming-mong.test/ws", client.Options{NetDialContext: dial}); err != nil {
+		t.Fatalf("Ping after the ban expired: %v", err)
+	}
+}
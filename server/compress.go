@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// withCompression compresses responses with gzip or deflate when the client
+// accepts it. Images, partial content and bodiless responses are passed
+// through untouched.
+func (s *Server) withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.config.Compression || r.Header.Get("Range") != "" || isImagePath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Validators the client got from an encoded response refer to
+		// the unencoded one as far as next is concerned
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			unencoded := strings.ReplaceAll(match, "-"+encoding+`"`, `"`)
+			r.Header.Set("If-None-Match", unencoded)
+			cw.revalidatesEncoded = unencoded != match
+		}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// isImagePath reports whether path names an image, which is never
+// compressed, so its validators are left alone as well
+func isImagePath(path string) bool {
+	return strings.HasPrefix(mime.TypeByExtension(filepath.Ext(path)), "image/")
+}
+
+// negotiateEncoding picks gzip over deflate among the encodings the
+// client accepts with a non-zero quality
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		accepted[name] = quality > 0
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	// revalidatesEncoded is set when the client's If-None-Match named
+	// the encoded response
+	revalidatesEncoded bool
+	writer             io.WriteCloser
+	wroteHeader        bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	compressible := status != http.StatusNoContent &&
+		status != http.StatusNotModified &&
+		status != http.StatusPartialContent &&
+		header.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(header.Get("Content-Type"), "image/")
+
+	if compressible {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		// HTTP's deflate is zlib-wrapped, not raw DEFLATE
+		if cw.encoding == "gzip" {
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.writer = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+	// Only encoded responses get the suffix. A 304 carries the validator
+	// of the representation it confirms, encoded or not.
+	if etag := header.Get("ETag"); etag != "" && (compressible || (status == http.StatusNotModified && cw.revalidatesEncoded)) {
+		header.Set("ETag", encodedETag(etag, cw.encoding))
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// encodedETag gives each encoding of a response its own entity tag, "abc"
+// becoming "abc-gzip", so caches don't serve one for the other
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(data)
+	}
+	return cw.ResponseWriter.Write(data)
+}
+
+func (cw *compressWriter) Close() error {
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// Hijack keeps stealth mode working behind the compression wrapper
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return hijacker.Hijack()
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompressionETags(t *testing.T) {
+	s := &Server{config: Config{Compression: true}}
+	handler := s.withCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, strings.NewReader(strings.Repeat("x", 1000)))
+	}))
+
+	tests := []struct {
+		name        string
+		path        string
+		ifNoneMatch string
+		status      int
+		etag        string
+		encoding    string
+	}{
+		{"script", "/app.js", "", http.StatusOK, `"v1-gzip"`, "gzip"},
+		{"script revalidated", "/app.js", `"v1-gzip"`, http.StatusNotModified, `"v1-gzip"`, ""},
+		{"script revalidated unencoded", "/app.js", `"v1"`, http.StatusNotModified, `"v1"`, ""},
+		{"image", "/logo.png", "", http.StatusOK, `"v1"`, ""},
+		{"image revalidated", "/logo.png", `"v1"`, http.StatusNotModified, `"v1"`, ""},
+		{"image with an encoded validator", "/logo.png", `"v1-gzip"`, http.StatusOK, `"v1"`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			if test.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Fatalf("got status %d, want %d", rec.Code, test.status)
+			}
+			if etag := rec.Header().Get("ETag"); etag != test.etag {
+				t.Fatalf("got ETag %s, want %s", etag, test.etag)
+			}
+			if encoding := rec.Header().Get("Content-Encoding"); encoding != test.encoding {
+				t.Fatalf("got Content-Encoding %q, want %q", encoding, test.encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// conformanceReplyTimeout is how long a client may take to report its
+	// outcome of a case, on top of the case's own delay
+	conformanceReplyTimeout = 10 * time.Second
+	// conformanceLargeFrame is the size of the oversized pong
+	conformanceLargeFrame = 256 * 1024
+)
+
+// Outcomes a client reports for a conformance case
+const (
+	outcomeAccept = "accept"
+	outcomeReject = "reject"
+)
+
+// ConformanceCase announces a case: the next message is what a ping would
+// get as reply, and the client reports whether it accepted it as a pong
+type ConformanceCase struct {
+	Type        string   `json:"type"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Expect      []string `json:"expect"`
+}
+
+// ConformanceReply is the client's outcome of a case
+type ConformanceReply struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Outcome string `json:"outcome"`
+}
+
+// ConformanceCaseResult is the outcome of a single case
+type ConformanceCaseResult struct {
+	Name    string   `json:"name"`
+	Expect  []string `json:"expect"`
+	Outcome string   `json:"outcome"`
+	Passed  bool     `json:"passed"`
+}
+
+// ConformanceReport ends a run
+type ConformanceReport struct {
+	Type      string                  `json:"type"`
+	Passed    int                     `json:"passed"`
+	Failed    int                     `json:"failed"`
+	Cases     []ConformanceCaseResult `json:"cases"`
+	Timestamp string                  `json:"timestamp"`
+}
+
+// conformanceCase is a scripted server reply and the outcomes a correct
+// client reports for it
+type conformanceCase struct {
+	name        string
+	description string
+	expect      []string
+	delay       time.Duration
+	reply       func(now time.Time) []byte
+}
+
+// conformancePong encodes a pong with extra fields merged in
+func conformancePong(now time.Time, status string, extra map[string]interface{}) []byte {
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	message := map[string]interface{}{
+		"type":        "pong",
+		"status":      status,
+		"timestamp":   timestamp,
+		"server_time": timestamp,
+	}
+	for k, v := range extra {
+		message[k] = v
+	}
+	data, _ := json.Marshal(message)
+	return data
+}
+
+var conformanceCases = []conformanceCase{
+	{
+		name:        "valid_pong",
+		description: "A regular pong",
+		expect:      []string{outcomeAccept},
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "ok", nil)
+		},
+	},
+	{
+		name:        "degraded_pong",
+		description: "A pong from a server with a failing component, which still answered the ping",
+		expect:      []string{outcomeAccept},
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "degraded", map[string]interface{}{"reasons": []string{"clickhouse"}})
+		},
+	},
+	{
+		name:        "malformed_json",
+		description: "A truncated JSON document",
+		expect:      []string{outcomeReject},
+		reply: func(now time.Time) []byte {
+			pong := conformancePong(now, "ok", nil)
+			return pong[:len(pong)/2]
+		},
+	},
+	{
+		name:        "error_reply",
+		description: "An error instead of a pong, which must be surfaced rather than treated as success",
+		expect:      []string{outcomeReject},
+		reply: func(now time.Time) []byte {
+			data, _ := json.Marshal(PongMessage{Type: "error", Error: "invalid_signature", Timestamp: now.UTC().Format(time.RFC3339Nano)})
+			return data
+		},
+	},
+	{
+		name:        "wrong_type",
+		description: "Valid JSON that isn't a pong",
+		expect:      []string{outcomeReject},
+		reply: func(now time.Time) []byte {
+			return []byte(`{"type":"pang","timestamp":"` + now.UTC().Format(time.RFC3339Nano) + `"}`)
+		},
+	},
+	{
+		name:        "unknown_fields",
+		description: "A pong with fields and a status this client doesn't know, which must not break parsing",
+		expect:      []string{outcomeAccept},
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "overloaded", map[string]interface{}{
+				"region":   "eu-west",
+				"features": []string{"time", "probe"},
+				"limits":   map[string]int{"max_message_size": 4096},
+			})
+		},
+	},
+	{
+		name:        "newer_version",
+		description: "A pong from a newer protocol version, which clients must accept as long as the type matches",
+		expect:      []string{outcomeAccept},
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "ok", map[string]interface{}{"version": 2, "min_version": 1})
+		},
+	},
+	{
+		name:        "slow_pong",
+		description: "A pong after 3 seconds, within the timeout clients should allow",
+		expect:      []string{outcomeAccept},
+		delay:       3 * time.Second,
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "ok", nil)
+		},
+	},
+	{
+		name:        "oversized_frame",
+		description: "A pong padded to 256 KiB; clients may accept it or reject it, but must stay connected",
+		expect:      []string{outcomeAccept, outcomeReject},
+		reply: func(now time.Time) []byte {
+			return conformancePong(now, "ok", map[string]interface{}{"padding": strings.Repeat("x", conformanceLargeFrame)})
+		},
+	},
+}
+
+// handleConformance runs the conformance suite on /api/conformance. The
+// client starts it with a signed {"type": "conformance"} message, then for
+// each case gets a ConformanceCase followed by the scripted reply, and
+// answers with a ConformanceReply. A ConformanceReport ends the run.
+func (s *Server) handleConformance(w http.ResponseWriter, r *http.Request) {
+	s.activeConns.Add(1)
+	defer s.activeConns.Done()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stopOnClose := context.AfterFunc(s.connCtx, cancel)
+	defer stopOnClose()
+
+	clientIP := clientIPFromRequest(r)
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Info("Conformance upgrade failed", "client_ip", clientIP, "error", err)
+		return
+	}
+	defer conn.Close()
+	stop := s.closeOnCancel(ctx, conn)
+	defer stop()
+
+	conn.SetReadLimit(s.config.MaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	start, err := parsePing(data)
+	if err == nil && start.Type != "conformance" {
+		err = fmt.Errorf("%w: %q", ErrInvalidType, start.Type)
+	}
+	if err == nil && !s.signatures.Load().valid(start.KeyID, start.ClientID, start.Nonce, start.Signature, s.clock.Now()) {
+		err = fmt.Errorf("%w: %s", ErrInvalidSignature, start.Signature)
+	}
+	if err != nil {
+		slog.Info("Conformance run rejected", "client_ip", clientIP, "error", err)
+		s.sendError(conn, err)
+		return
+	}
+
+	// The suite waits on the client, however slow it is
+	watchedTaskFrom(r.Context()).done()
+	report := runConformance(ctx, conn, s.clock)
+	slog.Info("Conformance run", "client_ip", clientIP, "passed", report.Passed, "failed", report.Failed)
+	if jsonData, err := json.Marshal(report); err == nil {
+		conn.SetWriteDeadline(time.Now().Add(conformanceReplyTimeout))
+		conn.WriteMessage(websocket.TextMessage, jsonData)
+	}
+}
+
+// runConformance plays the cases in order. A case without a reply ends the
+// run, since the connection can't be trusted afterwards.
+func runConformance(ctx context.Context, conn *websocket.Conn, clock Clock) ConformanceReport {
+	report := ConformanceReport{Type: "conformance_report", Cases: []ConformanceCaseResult{}}
+	broken := false
+
+	for _, c := range conformanceCases {
+		result := ConformanceCaseResult{Name: c.name, Expect: c.expect, Outcome: "not_run"}
+		if !broken && ctx.Err() == nil {
+			result.Outcome = playConformanceCase(conn, c, clock)
+			broken = result.Outcome == "no_reply"
+		}
+		for _, outcome := range c.expect {
+			result.Passed = result.Passed || result.Outcome == outcome
+		}
+
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	report.Timestamp = clock.Now().UTC().Format(time.RFC3339Nano)
+	return report
+}
+
+// playConformanceCase announces and plays a case, returning the client's
+// outcome
+func playConformanceCase(conn *websocket.Conn, c conformanceCase, clock Clock) string {
+	announcement, _ := json.Marshal(ConformanceCase{Type: "case", Name: c.name, Description: c.description, Expect: c.expect})
+	conn.SetWriteDeadline(time.Now().Add(conformanceReplyTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, announcement); err != nil {
+		return "no_reply"
+	}
+
+	time.Sleep(c.delay)
+	if err := conn.WriteMessage(websocket.TextMessage, c.reply(clock.Now())); err != nil {
+		return "no_reply"
+	}
+
+	conn.SetReadDeadline(time.Now().Add(conformanceReplyTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "no_reply"
+	}
+	var reply ConformanceReply
+	if err := json.Unmarshal(data, &reply); err != nil || reply.Type != "case_result" || reply.Name != c.name {
+		return "invalid_reply"
+	}
+	return reply.Outcome
+}
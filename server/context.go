@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The server's lifetime is split in three contexts: drainCtx ends when a
+// shutdown starts and asks kept-alive connections to leave; connCtx is the
+// parent of every connection context and is cancelled when a shutdown
+// gives up waiting for in-flight connections; workerCtx stops the
+// background workers (sinks, certificate reloads) once no connection can
+// record samples anymore, letting them flush what they have queued.
+
+// startWorker runs a background loop until the server is closed
+func (s *Server) startWorker(run func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		defer s.reportPanic("worker")
+		run(s.workerCtx)
+	}()
+}
+
+// drain stops httpServer accepting connections and waits up to timeout for
+// in-flight ones to finish before cancelling them
+func (s *Server) drain(httpServer *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Warn("Drain incomplete", "timeout", timeout, "error", err)
+	}
+
+	// WebSocket connections are hijacked, so Shutdown doesn't wait for them
+	done := make(chan struct{})
+	go func() {
+		s.activeConns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Info("Closing remaining WebSocket connections")
+		s.cancelConnections()
+		<-done
+	}
+}
+
+// goingAway closes conn with status 1001, so the client knows to reconnect
+// elsewhere rather than seeing a connection reset
+func goingAway(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+		time.Now().Add(keepAliveWriteTimeout))
+	conn.Close()
+}
+
+// closeOnCancel closes conn when ctx ends, with a close frame when the
+// reason is a shutdown that stopped waiting for it
+func (s *Server) closeOnCancel(ctx context.Context, conn *websocket.Conn) (stop func() bool) {
+	return context.AfterFunc(ctx, func() {
+		if s.connCtx.Err() != nil {
+			goingAway(conn)
+			return
+		}
+		conn.Close()
+	})
+}
+
+// Close ends any remaining connections and stops the background workers
+// after they have flushed queued samples. Serve and Run close the server
+// when they return; code that mounts Handler on its own http.Server should
+// call Close after shutting that down.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		s.startDrain()
+		s.cancelConnections()
+		s.activeConns.Wait()
+
+		s.stopWorkers()
+		s.workers.Wait()
+		if s.authLog != nil {
+			s.authLog.close()
+		}
+		if s.events != nil {
+			s.events.close()
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	crashReportTimeout = 5 * time.Second
+	// maxPanicReports is how many panics /admin/watchdog lists
+	maxPanicReports = 20
+)
+
+// crashReporter sends panics to a Sentry-compatible endpoint, so a crash
+// is known even when nobody reads the logs
+type crashReporter struct {
+	endpoint string
+	auth     string
+	client   *http.Client
+
+	mu     sync.Mutex
+	panics []panicReport
+}
+
+// panicReport is a panic as listed by /admin/watchdog
+type panicReport struct {
+	Time    time.Time `json:"time"`
+	Where   string    `json:"where"`
+	Value   string    `json:"value"`
+	EventID string    `json:"event_id"`
+}
+
+// newCrashReporter parses a Sentry DSN,
+// https://<key>[:<secret>]@<host>[/<path>]/<project>
+func newCrashReporter(dsn string, proxy func(*http.Request) (*url.URL, error), dialer Dialer) (*crashReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid crash report DSN: %w", err)
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" || slash < 0 || path[slash+1:] == "" {
+		return nil, fmt.Errorf("invalid crash report DSN %q: want https://key@host/project", u.Redacted())
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=ming-mong/%s, sentry_key=%s", Version, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return &crashReporter{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:slash], path[slash+1:]),
+		auth:     auth,
+		client:   &http.Client{Timeout: crashReportTimeout, Transport: proxiedTransport(proxy, dialer)},
+	}, nil
+}
+
+// sentryEvent is the subset of the Sentry event payload sent for a panic
+type sentryEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger"`
+	ServerName string            `json:"server_name,omitempty"`
+	Release    string            `json:"release"`
+	Tags       map[string]string `json:"tags"`
+	Exception  struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// report sends a panic with the stack of pcs and waits for the endpoint,
+// as the process may be about to exit
+func (c *crashReporter) report(where string, value any, pcs []uintptr) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := sentryEvent{
+		EventID:   hex.EncodeToString(id),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Level:     "fatal",
+		Platform:  "go",
+		Logger:    "ming-mong",
+		Release:   Version,
+		Tags:      map[string]string{"where": where},
+	}
+	event.ServerName, _ = os.Hostname()
+
+	exception := sentryException{Type: "panic", Value: fmt.Sprint(value)}
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		exception.Stacktrace.Frames = append(exception.Stacktrace.Frames, sentryFrame{
+			Function: frame.Function,
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "ming-mong/"),
+		})
+		if !more {
+			break
+		}
+	}
+	// Sentry lists the outermost frame first
+	for i, j := 0, len(exception.Stacktrace.Frames)-1; i < j; i, j = i+1, j-1 {
+		exception.Stacktrace.Frames[i], exception.Stacktrace.Frames[j] = exception.Stacktrace.Frames[j], exception.Stacktrace.Frames[i]
+	}
+	event.Exception.Values = []sentryException{exception}
+
+	c.mu.Lock()
+	c.panics = append(c.panics, panicReport{Time: time.Now().UTC(), Where: where, Value: exception.Value, EventID: event.EventID})
+	if len(c.panics) > maxPanicReports {
+		c.panics = c.panics[len(c.panics)-maxPanicReports:]
+	}
+	c.mu.Unlock()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), crashReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		slog.Error("Crash report failed", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Error("Crash report rejected", "status", resp.StatusCode)
+	}
+}
+
+// recent returns the last panics reported, oldest first
+func (c *crashReporter) recent() []panicReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]panicReport(nil), c.panics...)
+}
+
+// reportPanic is deferred by goroutines that should have their panics
+// reported; it re-panics, so the panic is handled as it would be otherwise
+func (s *Server) reportPanic(where string) {
+	if s.crashes == nil {
+		return
+	}
+	value := recover()
+	if value == nil || value == http.ErrAbortHandler {
+		if value != nil {
+			panic(value)
+		}
+		return
+	}
+	// The panicking frames are still on the stack while deferred calls run
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(3, pcs)]
+	s.crashes.report(where, value, pcs)
+	panic(value)
+}
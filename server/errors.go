@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Errors returned by the validation and handler layers. They may be wrapped
+// with details, so compare with errors.Is.
+var (
+	ErrInvalidFormat    = errors.New("invalid message format")
+	ErrInvalidType      = errors.New("invalid message type")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrOversizedMessage = errors.New("message exceeds size limit")
+	ErrInvalidProbe     = errors.New("invalid probe sizes")
+	ErrDenied           = errors.New("denied by auth hook")
+	ErrRateLimited      = errors.New("connection rate limit exceeded")
+	// ErrEncryptionRequired rejects plaintext messages when
+	// Config.EncryptionRequired is set
+	ErrEncryptionRequired = errors.New("message encryption required")
+	ErrUndecryptable      = errors.New("sealed message could not be opened")
+	// ErrNonceRequired rejects pings without a nonce when
+	// Config.NonceRequired is set
+	ErrNonceRequired = errors.New("nonce required")
+	ErrReplayed      = errors.New("nonce already used")
+
+	// ErrOutsideWindow rejects correctly signed pings from a key or client
+	// outside its Config.AccessWindows
+	ErrOutsideWindow = errors.New("outside access window")
+)
+
+// errorCodes are the wire error codes sent to clients
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrInvalidFormat, "invalid_format"},
+	{ErrInvalidType, "invalid_type"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{ErrOversizedMessage, "message_too_large"},
+	{ErrInvalidProbe, "invalid_probe"},
+	{ErrDenied, "denied"},
+	{ErrRateLimited, "rate_limited"},
+	{ErrEncryptionRequired, "encryption_required"},
+	{ErrUndecryptable, "decryption_failed"},
+	{ErrNonceRequired, "nonce_required"},
+	{ErrReplayed, "replayed"},
+	{ErrOutsideWindow, "outside_access_window"},
+}
+
+// errorCode maps an error to its wire error code
+func errorCode(err error) string {
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return "internal_error"
+}
+
+// sendError reports err to the client as an error message
+func (s *Server) sendError(conn replyWriter, err error) {
+	errorMsg := PongMessage{
+		Type:      "error",
+		Error:     errorCode(err),
+		Timestamp: s.clock.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	if jsonData, err := json.Marshal(errorMsg); err == nil {
+		conn.WriteMessage(websocket.TextMessage, jsonData)
+	}
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxEvents is how much operational history is kept and served
+const maxEvents = 200
+
+// serverEvent is one entry of the operational history: a start, shutdown
+// or reload, a component becoming unhealthy or recovering, or scheduled
+// maintenance
+type serverEvent struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Title  string    `json:"title"`
+	Detail string    `json:"detail,omitempty"`
+	// End is set for scheduled maintenance
+	End *time.Time `json:"end,omitempty"`
+}
+
+// eventLog keeps the last maxEvents events, appending each to file when
+// set so the history survives restarts
+type eventLog struct {
+	clock Clock
+
+	mu     sync.Mutex
+	events []serverEvent
+	file   *os.File
+	// seq tells apart events recorded at the same instant
+	seq uint64
+}
+
+// newEventLog loads the history from path when set
+func newEventLog(path string, clock Clock) (*eventLog, error) {
+	log := &eventLog{clock: clock}
+	if path == "" {
+		return log, nil
+	}
+
+	lines := 0
+	if existing, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(existing)
+		for scanner.Scan() {
+			lines++
+			var event serverEvent
+			if json.Unmarshal(scanner.Bytes(), &event) == nil {
+				log.append(event)
+			}
+		}
+		existing.Close()
+	}
+	// Only the last maxEvents are ever served, so the file is cut down to
+	// them instead of growing with every restart
+	if lines > len(log.events) {
+		if err := log.compact(path); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open events file: %w", err)
+	}
+	log.file = file
+	return log, nil
+}
+
+// compact replaces the file at path with the events kept in memory, by
+// renaming a complete copy over it so a crash can't lose the history
+func (l *eventLog) compact(path string) error {
+	var buf bytes.Buffer
+	for _, event := range l.events {
+		line, _ := json.Marshal(event)
+		buf.Write(append(line, '\n'))
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("compact events file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compact events file: %w", err)
+	}
+	return nil
+}
+
+func (l *eventLog) append(event serverEvent) {
+	l.events = append(l.events, event)
+	if len(l.events) > maxEvents {
+		l.events = l.events[len(l.events)-maxEvents:]
+	}
+}
+
+// record adds an event of kind happening now
+func (l *eventLog) record(kind, title, detail string) {
+	now := l.clock.Now().UTC()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	event := serverEvent{
+		ID:     strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(l.seq, 36),
+		Time:   now,
+		Kind:   kind,
+		Title:  title,
+		Detail: detail,
+	}
+	l.append(event)
+	if l.file != nil {
+		line, _ := json.Marshal(event)
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			slog.Warn("Failed to write events file", "error", err)
+		}
+	}
+}
+
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// feed returns the recorded events and the maintenance windows that
+// haven't ended yet, newest first
+func (l *eventLog) feed(windows []MaintenanceWindow, now time.Time) []serverEvent {
+	l.mu.Lock()
+	events := append([]serverEvent(nil), l.events...)
+	l.mu.Unlock()
+
+	for _, window := range windows {
+		if !window.End.After(now) {
+			continue
+		}
+		end := window.End.UTC()
+		events = append(events, serverEvent{
+			ID:     "maintenance-" + strconv.FormatInt(window.Start.Unix(), 36),
+			Time:   window.Start.UTC(),
+			Kind:   "maintenance",
+			Title:  "Scheduled maintenance",
+			Detail: fmt.Sprintf("%s to %s", window.Start.UTC().Format(time.RFC3339), end.Format(time.RFC3339)),
+			End:    &end,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events
+}
+
+// healthEvents turns changes in the health registry into events; a
+// problem changing only its reason is not news
+func (l *eventLog) healthEvents(component string, previous, current *healthProblem) {
+	switch {
+	case current == nil:
+		l.record("recovered", component+" recovered", "")
+	case previous == nil || previous.level != current.level:
+		l.record("outage", component+" "+current.level.String(), current.reason)
+	}
+}
+
+// atomFeed and rssFeed are the XML documents served for feed readers
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title    string `xml:"title"`
+	ID       string `xml:"id"`
+	Updated  string `xml:"updated"`
+	Category struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+	Summary string `xml:"summary,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// rssGUID identifies an item without being a link to it
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Category    string  `xml:"category"`
+	Description string  `xml:"description,omitempty"`
+}
+
+// handleEvents serves the operational history as JSON at /api/events,
+// Atom at /api/events.atom and RSS at /api/events.rss
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	now := s.clock.Now()
+	events := s.events.feed(s.maintenance.Load().windows, now)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host + "/api/events"
+
+	w.Header().Set("Cache-Control", "no-cache")
+	switch r.URL.Path {
+	case "/api/events.atom":
+		feed := atomFeed{Title: "ming-mong events", ID: base, Link: atomLink{Href: base + ".atom", Rel: "self"}, Updated: now.UTC().Format(time.RFC3339)}
+		// Upcoming maintenance doesn't count as an update yet
+		for _, event := range events {
+			if !event.Time.After(now) {
+				feed.Updated = event.Time.Format(time.RFC3339)
+				break
+			}
+		}
+		for _, event := range events {
+			entry := atomEntry{Title: event.Title, ID: base + "#" + event.ID, Updated: event.Time.Format(time.RFC3339), Summary: event.Detail}
+			entry.Category.Term = event.Kind
+			feed.Entries = append(feed.Entries, entry)
+		}
+		writeXML(w, "application/atom+xml", feed)
+	case "/api/events.rss":
+		feed := rssFeed{Version: "2.0"}
+		feed.Channel.Title = "ming-mong events"
+		feed.Channel.Link = base
+		feed.Channel.Description = "Starts, shutdowns, reloads, health changes and scheduled maintenance"
+		for _, event := range events {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       event.Title,
+				GUID:        rssGUID{Value: base + "#" + event.ID},
+				PubDate:     event.Time.Format(time.RFC1123Z),
+				Category:    event.Kind,
+				Description: event.Detail,
+			})
+		}
+		writeXML(w, "application/rss+xml", feed)
+	default:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+	}
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	encoder.Encode(v)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventIDsUniqueAtOneInstant(t *testing.T) {
+	log, err := newEventLog("", NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.record("reload", "Configuration reloaded", "")
+	log.record("reload", "Configuration reloaded", "")
+
+	if len(log.events) != 2 {
+		t.Fatalf("got %d events, want 2", len(log.events))
+	}
+	if first, second := log.events[0], log.events[1]; first.ID == second.ID {
+		t.Fatalf("both events have ID %q", first.ID)
+	}
+}
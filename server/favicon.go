@@ -0,0 +1,19 @@
+package server
+
+import "net/http"
+
+// newFaviconHandler serves a green, amber or red favicon depending on the
+// current server health, so browser tabs show the state at a glance
+func newFaviconHandler(health *healthRegistry) http.HandlerFunc {
+	icons := map[HealthLevel]*asset{
+		HealthOK:       loadAsset("favicon-green.png"),
+		HealthDegraded: loadAsset("favicon-amber.png"),
+		HealthFailing:  loadAsset("favicon-red.png"),
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		level, _ := health.current()
+		// Revalidate on every load so the colour follows the health state
+		icons[level].serve(w, r, "no-cache")
+	}
+}
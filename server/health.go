@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// HealthLevel is the overall state reported by the favicon and the
+// configuration check
+type HealthLevel int
+
+const (
+	HealthOK HealthLevel = iota
+	// HealthDegraded means an optional component is failing while pings
+	// are still answered normally
+	HealthDegraded
+	// HealthFailing means clients are likely unable to reach the server
+	// the way it was configured
+	HealthFailing
+)
+
+var healthLevelNames = map[HealthLevel]string{
+	HealthOK:       "ok",
+	HealthDegraded: "degraded",
+	HealthFailing:  "failing",
+}
+
+func (l HealthLevel) String() string {
+	if name, ok := healthLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("HealthLevel(%d)", int(l))
+}
+
+type healthProblem struct {
+	level  HealthLevel
+	reason string
+}
+
+// healthRegistry collects the problems reported by components
+type healthRegistry struct {
+	mu       sync.Mutex
+	problems map[string]healthProblem
+	// onChange, when set, is called after a component's problem changes;
+	// previous or current is nil when there was or is no problem
+	onChange func(component string, previous, current *healthProblem)
+}
+
+func newHealthRegistry() *healthRegistry {
+	return &healthRegistry{problems: make(map[string]healthProblem)}
+}
+
+// set records that component is unhealthy, replacing any previous problem
+// reported by the same component
+func (h *healthRegistry) set(component string, level HealthLevel, reason string) {
+	current := healthProblem{level: level, reason: reason}
+	h.mu.Lock()
+	previous, existed := h.problems[component]
+	h.problems[component] = current
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if onChange != nil && (!existed || previous != current) {
+		if existed {
+			onChange(component, &previous, &current)
+		} else {
+			onChange(component, nil, &current)
+		}
+	}
+}
+
+func (h *healthRegistry) clear(component string) {
+	h.mu.Lock()
+	previous, existed := h.problems[component]
+	delete(h.problems, component)
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if onChange != nil && existed {
+		onChange(component, &previous, nil)
+	}
+}
+
+// current returns the worst reported level and all reasons, sorted by
+// component name
+func (h *healthRegistry) current() (HealthLevel, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	components := make([]string, 0, len(h.problems))
+	for component := range h.problems {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	level := HealthOK
+	reasons := make([]string, 0, len(components))
+	for _, component := range components {
+		problem := h.problems[component]
+		if problem.level > level {
+			level = problem.level
+		}
+		reasons = append(reasons, component+": "+problem.reason)
+	}
+	return level, reasons
+}
+
+// components returns the components reporting a problem, sorted, or nil
+// when there are none
+func (h *healthRegistry) components() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var components []string
+	for component := range h.problems {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	return components
+}
+
+// SetHealthProblem reports a problem with component, e.g. from code
+// embedding the server; it shows in the favicon colour
+func (s *Server) SetHealthProblem(component string, level HealthLevel, reason string) {
+	s.health.set(component, level, reason)
+}
+
+// ClearHealthProblem removes a problem reported for component
+func (s *Server) ClearHealthProblem(component string) {
+	s.health.clear(component)
+}
+
+// Health returns the worst reported level and the reported problems
+func (s *Server) Health() (HealthLevel, []string) {
+	return s.health.current()
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// healthReport is the JSON body of /healthz and /readyz
+type healthReport struct {
+	Status   string   `json:"status"`
+	Health   string   `json:"health"`
+	Problems []string `json:"problems"`
+	// Reasons are the components behind a "degraded" status
+	Reasons  []string `json:"reasons,omitempty"`
+	Draining bool     `json:"draining,omitempty"`
+	Version  string   `json:"version"`
+	UptimeS  int64    `json:"uptime_s"`
+}
+
+// ParseHealthAllow reads the HEALTH_ALLOW networks, see ParseNetworks
+func ParseHealthAllow(value string) ([]*net.IPNet, error) {
+	return ParseNetworks(value)
+}
+
+// newHealthzHandler serves liveness (/healthz) and readiness (/readyz)
+// for orchestrators, without a signed ping. When allow is set, only
+// connections from those networks are answered; the socket address is
+// used since proxy headers could be forged.
+func (s *Server) newHealthzHandler(allow []*net.IPNet, ready bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedPeer(r, allow) {
+			dropConnection(w)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			s.rejectMethod(w, http.MethodGet, http.MethodHead)
+			return
+		}
+
+		level, problems := s.health.current()
+		report := healthReport{
+			Status:   "ok",
+			Health:   level.String(),
+			Problems: problems,
+			Draining: s.drainCtx.Err() != nil,
+			Version:  Version,
+			UptimeS:  int64(s.clock.Now().Sub(s.started).Seconds()),
+		}
+		// Operators get every component, whatever clients are told
+		if reasons := s.health.components(); len(reasons) > 0 {
+			report.Status = "degraded"
+			report.Reasons = reasons
+		}
+		status := http.StatusOK
+		// Readiness fails while draining, so traffic moves away before the
+		// listeners close, and when clients likely can't reach the server
+		if ready && (report.Draining || level == HealthFailing) {
+			report.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// dependencyComponents are the health components of backends pings go
+// through. Problems elsewhere, such as certificates, auth anomalies or the
+// watchdog, are for operators and don't change what clients are told.
+var dependencyComponents = map[string]bool{
+	"clickhouse": true,
+	"ratelimit":  true,
+}
+
+// degradedReasons returns the dependencies reporting a problem, reported
+// to clients with status "degraded" when DegradedStatus is on. Only the
+// names are given out; the problems may name internal hosts.
+func (s *Server) degradedReasons() []string {
+	if !s.config.DegradedStatus {
+		return nil
+	}
+	var reasons []string
+	for _, component := range s.health.components() {
+		if dependencyComponents[component] {
+			reasons = append(reasons, component)
+		}
+	}
+	return reasons
+}
+
+// allowedPeer reports whether the connection comes from one of allow, or
+// allow is empty
+func allowedPeer(r *http.Request, allow []*net.IPNet) bool {
+	return len(allow) == 0 || containsAddr(allow, socketAddr(r))
+}
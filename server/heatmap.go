@@ -0,0 +1,154 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// clientOther collects the clients beyond the heatmap's capacity
+const clientOther = "(other)"
+
+// latencyHeatmap counts successful ping latencies per client, hour and
+// histogram bucket, so dashboards can draw day-by-hour heatmaps without
+// fetching raw samples. Hours older than the retention are dropped and at
+// most maxClients clients are tracked, later ones count as clientOther.
+type latencyHeatmap struct {
+	mu         sync.Mutex
+	retention  int
+	maxClients int
+	clients    map[string]*clientHeatmap
+}
+
+// clientHeatmap holds one client's counts by hour (Unix time / 3600); each
+// has a bucket per histogram bound plus one above the largest
+type clientHeatmap struct {
+	hours map[int64][]uint64
+}
+
+func newLatencyHeatmap(retention, maxClients int) *latencyHeatmap {
+	return &latencyHeatmap{
+		retention:  retention,
+		maxClients: maxClients,
+		clients:    make(map[string]*clientHeatmap),
+	}
+}
+
+func (h *latencyHeatmap) Record(sample PingSample) {
+	if sample.Result != "ok" {
+		return
+	}
+	hour := sample.Timestamp.Unix() / 3600
+	bucket := sort.SearchFloat64s(histogramBounds, sample.LatencyMs)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client, ok := h.clients[sample.IP]
+	if !ok {
+		if len(h.clients) >= h.maxClients {
+			h.expire(hour)
+		}
+		key := sample.IP
+		if len(h.clients) >= h.maxClients {
+			key = clientOther
+		}
+		if client, ok = h.clients[key]; !ok {
+			client = &clientHeatmap{hours: make(map[int64][]uint64)}
+			h.clients[key] = client
+		}
+	}
+
+	counts, ok := client.hours[hour]
+	if !ok {
+		counts = make([]uint64, len(histogramBounds)+1)
+		client.hours[hour] = counts
+		for old := range client.hours {
+			if old <= hour-int64(h.retention) {
+				delete(client.hours, old)
+			}
+		}
+	}
+	counts[bucket]++
+}
+
+// expire drops hours past the retention and clients left without any
+func (h *latencyHeatmap) expire(now int64) {
+	for key, client := range h.clients {
+		for hour := range client.hours {
+			if hour <= now-int64(h.retention) {
+				delete(client.hours, hour)
+			}
+		}
+		if len(client.hours) == 0 {
+			delete(h.clients, key)
+		}
+	}
+}
+
+// heatmapRow is one client's counts, a bucket list per hour of the
+// heatmap's time axis
+type heatmapRow struct {
+	Client string     `json:"client"`
+	Total  uint64     `json:"total"`
+	Counts [][]uint64 `json:"counts"`
+}
+
+// heatmapSnapshot is the JSON form: a shared time axis, the bucket upper
+// bounds (the last bucket has none) and a row per client
+type heatmapSnapshot struct {
+	Hours    []time.Time  `json:"hours"`
+	BoundsMs []float64    `json:"bounds_ms"`
+	Clients  []heatmapRow `json:"clients"`
+}
+
+// snapshot returns the last hours hours up to now for the limit busiest
+// clients, or only for client when set
+func (h *latencyHeatmap) snapshot(now time.Time, hours int, client string, limit int) heatmapSnapshot {
+	if hours > h.retention {
+		hours = h.retention
+	}
+	last := now.Unix() / 3600
+	first := last - int64(hours) + 1
+
+	snapshot := heatmapSnapshot{
+		Hours:    make([]time.Time, hours),
+		BoundsMs: histogramBounds,
+		Clients:  []heatmapRow{},
+	}
+	for i := range snapshot.Hours {
+		snapshot.Hours[i] = time.Unix((first+int64(i))*3600, 0).UTC()
+	}
+
+	h.mu.Lock()
+	for key, entry := range h.clients {
+		if client != "" && key != client {
+			continue
+		}
+		row := heatmapRow{Client: key, Counts: make([][]uint64, hours)}
+		for i := range row.Counts {
+			row.Counts[i] = make([]uint64, len(histogramBounds)+1)
+			if counts, ok := entry.hours[first+int64(i)]; ok {
+				copy(row.Counts[i], counts)
+				for _, count := range counts {
+					row.Total += count
+				}
+			}
+		}
+		if row.Total > 0 {
+			snapshot.Clients = append(snapshot.Clients, row)
+		}
+	}
+	h.mu.Unlock()
+
+	sort.Slice(snapshot.Clients, func(i, j int) bool {
+		if snapshot.Clients[i].Total != snapshot.Clients[j].Total {
+			return snapshot.Clients[i].Total > snapshot.Clients[j].Total
+		}
+		return snapshot.Clients[i].Client < snapshot.Clients[j].Client
+	})
+	if len(snapshot.Clients) > limit {
+		snapshot.Clients = snapshot.Clients[:limit]
+	}
+	return snapshot
+}
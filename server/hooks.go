@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxAuthHookDecisions bounds the cached auth hook decisions
+	maxAuthHookDecisions = 10000
+	// eventHookGrace is how long the event hook may take to exit once its
+	// stdin is closed before it is killed
+	eventHookGrace = 5 * time.Second
+)
+
+// authHook runs an external command for every ping with a valid signature
+// and lets it reject the client, so site-specific policies (IP allow lists,
+// per-tenant quotas, ...) don't need a rebuild.
+//
+// The command gets an authRequest as JSON on stdin and answers with an
+// authDecision on stdout. Failures and timeouts deny the ping. Anyone
+// holding the public signing secret gets this far, so at most a fixed
+// number of hooks run at once, pings beyond that are denied, and decisions
+// are reused per client IP and key for cacheTTL.
+type authHook struct {
+	command  string
+	timeout  time.Duration
+	cacheTTL time.Duration
+	clock    Clock
+	slots    chan struct{}
+
+	// saturated counts pings denied because every slot was taken
+	saturated atomic.Int64
+
+	mu        sync.Mutex
+	decisions map[authHookKey]cachedDecision
+}
+
+// authHookKey is what cached decisions are looked up by
+type authHookKey struct {
+	ip, keyID string
+}
+
+type cachedDecision struct {
+	allow   bool
+	expires time.Time
+}
+
+// authRequest is what an auth hook decides on
+type authRequest struct {
+	IP        string `json:"ip"`
+	Client    string `json:"client"`
+	Signature string `json:"signature"`
+	KeyID     string `json:"key_id,omitempty"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Tag       string `json:"tag,omitempty"`
+}
+
+// authDecision is the auth hook's answer
+type authDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func newAuthHook(command string, timeout time.Duration, concurrency int, cacheTTL time.Duration, clock Clock) *authHook {
+	return &authHook{
+		command:   command,
+		timeout:   timeout,
+		cacheTTL:  cacheTTL,
+		clock:     clock,
+		slots:     make(chan struct{}, concurrency),
+		decisions: make(map[authHookKey]cachedDecision),
+	}
+}
+
+// allow reports whether the hook accepts the ping, from the cache when it
+// decided on the same client IP and key recently. The hook is killed when
+// ctx is cancelled.
+func (h *authHook) allow(ctx context.Context, request authRequest) bool {
+	key := authHookKey{ip: request.IP, keyID: request.KeyID}
+	now := h.clock.Now()
+	h.mu.Lock()
+	cached, ok := h.decisions[key]
+	h.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.allow
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		h.saturated.Add(1)
+		return false
+	}
+
+	allow := h.run(ctx, request)
+	h.remember(key, cachedDecision{allow: allow, expires: now.Add(h.cacheTTL)})
+	return allow
+}
+
+// remember caches decision for key, dropping expired decisions when the
+// cache is full and skipping it if that doesn't make room
+func (h *authHook) remember(key authHookKey, decision cachedDecision) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.decisions[key]; !ok && len(h.decisions) >= maxAuthHookDecisions {
+		now := h.clock.Now()
+		for k, d := range h.decisions {
+			if !now.Before(d.expires) {
+				delete(h.decisions, k)
+			}
+		}
+		if len(h.decisions) >= maxAuthHookDecisions {
+			return
+		}
+	}
+	h.decisions[key] = decision
+}
+
+// run asks the hook command about request
+func (h *authHook) run(ctx context.Context, request authRequest) bool {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.Output()
+	if err != nil {
+		slog.Error("Auth hook failed", "client_ip", request.IP, "error", err)
+		return false
+	}
+
+	var decision authDecision
+	if err := json.Unmarshal(output, &decision); err != nil {
+		slog.Error("Auth hook returned invalid JSON", "client_ip", request.IP, "error", err)
+		return false
+	}
+	if !decision.Allow {
+		slog.Info("Auth hook denied", "client_ip", request.IP, "reason", decision.Reason)
+	}
+	return decision.Allow
+}
+
+// eventHook streams ping samples as JSON lines to the stdin of a long
+// running command, restarting it when it exits. Samples are dropped rather
+// than slowing down pings when the command can't keep up.
+type eventHook struct {
+	command string
+	samples chan PingSample
+	dropped droppedSamples
+}
+
+func newEventHook(command string) *eventHook {
+	return &eventHook{command: command, samples: make(chan PingSample, 1024)}
+}
+
+func (h *eventHook) Record(sample PingSample) {
+	select {
+	case h.samples <- sample:
+	default:
+		h.dropped.add()
+	}
+}
+
+// run keeps the command running until ctx is cancelled, then passes on the
+// queued samples and closes its stdin
+func (h *eventHook) run(ctx context.Context) {
+	go h.dropped.reportEvery(ctx, "Event hook queue full, dropped samples")
+	for ctx.Err() == nil {
+		if err := h.feed(ctx); err != nil {
+			slog.Error("Event hook exited", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// feed starts the command and writes samples to it until it exits or ctx
+// is cancelled
+func (h *eventHook) feed(ctx context.Context) error {
+	cmd := exec.Command(h.command)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	encoder := json.NewEncoder(stdin)
+	for {
+		select {
+		case err := <-exited:
+			return err
+		case sample := <-h.samples:
+			if err := encoder.Encode(sample); err != nil {
+				cmd.Process.Kill()
+				return <-exited
+			}
+		case <-ctx.Done():
+			// A command that ignores EOF or stops reading must not hold
+			// up the shutdown
+			kill := time.AfterFunc(eventHookGrace, func() { cmd.Process.Kill() })
+			defer kill.Stop()
+			for len(h.samples) > 0 {
+				if encoder.Encode(<-h.samples) != nil {
+					break
+				}
+			}
+			stdin.Close()
+			return <-exited
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeHook writes an auth hook script that appends a line to calls on
+// every run and answers with decision after sleeping for delay
+func writeHook(t *testing.T, decision, delay string) (command, calls string) {
+	t.Helper()
+	dir := t.TempDir()
+	calls = filepath.Join(dir, "calls")
+	command = filepath.Join(dir, "hook.sh")
+	script := "#!/bin/sh\ncat >/dev/null\necho run >>" + calls + "\nsleep " + delay + "\necho '" + decision + "'\n"
+	if err := os.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return command, calls
+}
+
+func countCalls(t *testing.T, calls string) int {
+	t.Helper()
+	data, err := os.ReadFile(calls)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "run")
+}
+
+func TestAuthHookCachesDecisions(t *testing.T) {
+	command, calls := writeHook(t, `{"allow":true}`, "0")
+	clock := NewFakeClock(time.Now())
+	hook := newAuthHook(command, 5*time.Second, 1, time.Minute, clock)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if !hook.allow(ctx, authRequest{IP: "192.0.2.1", KeyID: "v2"}) {
+			t.Fatal("hook denied")
+		}
+	}
+	if n := countCalls(t, calls); n != 1 {
+		t.Fatalf("hook ran %d times for one client, want 1", n)
+	}
+
+	// Another key or IP, or an expired decision, asks the hook again
+	hook.allow(ctx, authRequest{IP: "192.0.2.1"})
+	hook.allow(ctx, authRequest{IP: "192.0.2.2", KeyID: "v2"})
+	clock.Advance(time.Minute)
+	hook.allow(ctx, authRequest{IP: "192.0.2.1", KeyID: "v2"})
+	if n := countCalls(t, calls); n != 4 {
+		t.Fatalf("hook ran %d times, want 4", n)
+	}
+}
+
+func TestAuthHookDeniesWhenSaturated(t *testing.T) {
+	command, _ := writeHook(t, `{"allow":true}`, "1")
+	hook := newAuthHook(command, 5*time.Second, 1, time.Minute, NewFakeClock(time.Now()))
+	ctx := context.Background()
+
+	running := make(chan bool)
+	go func() { running <- hook.allow(ctx, authRequest{IP: "192.0.2.1"}) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.slots) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if hook.allow(ctx, authRequest{IP: "192.0.2.2"}) {
+		t.Fatal("ping allowed while every hook slot was taken")
+	}
+	if n := hook.saturated.Load(); n != 1 {
+		t.Fatalf("saturated = %d, want 1", n)
+	}
+	if !<-running {
+		t.Fatal("running hook denied")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !no_http2 && !minimal
+
+package server
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+// configureHTTP2 serves HTTP/2 on httpServer once TLSNextProto is set
+func configureHTTP2(httpServer *http.Server) error {
+	return http2.ConfigureServer(httpServer, nil)
+}
@@ -0,0 +1,16 @@
+//go:build no_http2 || minimal
+
+package server
+
+import (
+	"net/http"
+	"slices"
+)
+
+// configureHTTP2 stops offering h2, which net/http no longer serves once
+// TLSNextProto is set, so clients fall back to HTTP/1.1
+func configureHTTP2(httpServer *http.Server) error {
+	httpServer.TLSConfig.NextProtos = slices.DeleteFunc(slices.Clone(httpServer.TLSConfig.NextProtos),
+		func(protocol string) bool { return protocol == "h2" })
+	return nil
+}
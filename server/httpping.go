@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// httpReplies writes the single reply of an HTTP ping as the response body
+type httpReplies struct {
+	w http.ResponseWriter
+}
+
+func (h httpReplies) WriteMessage(_ int, data []byte) error {
+	_, err := h.w.Write(data)
+	return err
+}
+
+// httpPingStatus is the HTTP status sent along with the error reply for
+// err, so clients and proxies that only look at the status still tell
+// rejections from success
+func httpPingStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrOversizedMessage):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrInvalidSignature), errors.Is(err, ErrNonceRequired),
+		errors.Is(err, ErrReplayed), errors.Is(err, ErrDenied), errors.Is(err, ErrOutsideWindow):
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// handleHTTPPing answers a PingMessage POSTed to /ping with the PongMessage
+// /ws would send, for clients that can't open WebSockets. Each request is
+// one exchange; probes need WebSocket frames and are refused.
+func (s *Server) handleHTTPPing(w http.ResponseWriter, r *http.Request) {
+	// Browsers may ping from any page, as they may open /ws
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		// CORS preflight, sent before a POST with a JSON Content-Type
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	clientIP := clientIPFromRequest(r)
+	tag := connectionTag(r)
+	s.stats.opened(clientIP)
+	defer s.stats.closed(clientIP)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	start := time.Now()
+	startedAt := s.clock.Now()
+	replies := replyWriter(httpReplies{w: w})
+	err := s.checkRateLimit(r.Context(), s.limitIP(r))
+
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, s.config.MaxMessageSize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = ErrOversizedMessage
+		}
+	}
+	if err == nil && s.sealer != nil {
+		data, replies, err = s.sealer.open(replies, data)
+	}
+	var pingMsg PingMessage
+	if err == nil {
+		pingMsg, err = parsePing(data)
+	}
+	if err == nil {
+		err = s.validatePing(r.Context(), r, clientIP, tag, pingMsg)
+	}
+	if err == nil && pingMsg.Type == "probe" {
+		err = fmt.Errorf("%w: %q over HTTP", ErrInvalidType, pingMsg.Type)
+	}
+
+	result := "ok"
+	if err != nil {
+		result = errorCode(err)
+		w.WriteHeader(httpPingStatus(err))
+		s.sendError(replies, err)
+	} else if pingMsg.Type == "time" {
+		result = "time"
+		sendTime(replies, pingMsg, start)
+	} else {
+		s.sendPong(replies, r, clientIP, pingMsg)
+	}
+
+	latency := time.Since(start)
+	logExchange(r, clientIP, tag, result, latency, err)
+	s.tags.record(tag, result)
+	s.recordSample(PingSample{
+		Client:    r.UserAgent(),
+		IP:        clientIP,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		Result:    result,
+		Timestamp: startedAt,
+		Tag:       tag,
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// keepAliveWriteTimeout bounds writing a control frame
+const keepAliveWriteTimeout = 5 * time.Second
+
+// keepAlive serves further pings on conn after the first one. The server
+// sends a control ping every KeepAliveInterval, so NAT and proxy idle
+// timeouts don't cut the connection; a peer that answers neither with a
+// pong nor with a message within KeepAliveTimeout after that is considered
+// dead and the connection is closed.
+func (s *Server) keepAlive(ctx context.Context, conn *websocket.Conn, r *http.Request, clientIP, tag string) {
+	interval := s.config.KeepAliveInterval
+	extend := func() {
+		conn.SetReadDeadline(time.Now().Add(interval + s.config.KeepAliveTimeout))
+	}
+	extend()
+
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+	// Client control pings also show the peer is alive
+	conn.SetPingHandler(func(data string) error {
+		extend()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(keepAliveWriteTimeout))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
+
+	// Draining tells clients to reconnect elsewhere instead of waiting for
+	// them to leave
+	stopDrain := context.AfterFunc(s.drainCtx, func() { goingAway(conn) })
+	defer stopDrain()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepAliveWriteTimeout)); err != nil {
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				slog.Info("Keepalive timeout, closing connection", "client_ip", clientIP, "tag", tag)
+			}
+			return
+		}
+		extend()
+
+		// Pings on a kept-alive connection count against the rate limit
+		// like the one that opened it
+		start := time.Now()
+		startedAt := s.clock.Now()
+		task := s.watchdog.begin("/ws keepalive", clientIP)
+		replies := replyWriter(conn)
+		err = s.checkRateLimit(ctx, s.limitIP(r))
+		if err == nil && s.sealer != nil {
+			data, replies, err = s.sealer.open(conn, data)
+		}
+		var pingMsg PingMessage
+		if err == nil {
+			pingMsg, err = parsePing(data)
+		}
+		if err == nil {
+			err = s.validatePing(ctx, r, clientIP, tag, pingMsg)
+		}
+		// Probing takes over the connection, so it has to come first
+		if err == nil && pingMsg.Type == "probe" {
+			err = fmt.Errorf("%w: %q after the first message", ErrInvalidType, pingMsg.Type)
+		}
+
+		result := "ok"
+		if err != nil {
+			result = errorCode(err)
+			s.sendError(replies, err)
+		} else if pingMsg.Type == "time" {
+			result = "time"
+			sendTime(replies, pingMsg, start)
+		} else {
+			s.sendPong(replies, r, clientIP, pingMsg)
+		}
+
+		latency := time.Since(start)
+		logExchange(r, clientIP, tag, result, latency, err)
+		s.tags.record(tag, result)
+		s.recordSample(PingSample{
+			Client:    r.UserAgent(),
+			IP:        clientIP,
+			LatencyMs: float64(latency.Microseconds()) / 1000,
+			Result:    result,
+			Timestamp: startedAt,
+			Tag:       tag,
+		})
+		task.done()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+func setKeepAliveParams(conn *net.TCPConn, idle, interval time.Duration, count int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if idle > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, int(idle.Seconds()))
+		}
+		if sockErr == nil && interval > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(interval.Seconds()))
+		}
+		if sockErr == nil && count > 0 {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, count)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// setKeepAliveParams falls back to the portable API, which only controls
+// the idle time; interval and count keep the OS defaults
+func setKeepAliveParams(conn *net.TCPConn, idle, interval time.Duration, count int) error {
+	if idle > 0 {
+		return conn.SetKeepAlivePeriod(idle)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// Version is set at build time with
+// -ldflags "-X ming-mong/server.Version=..."
+var Version = "dev"
+
+// landingData holds the variables available to landing page templates
+type landingData struct {
+	Host       string
+	WSEndpoint string
+	Version    string
+}
+
+// newLandingHandler serves the TLS landing page, rendered from templateFile
+// or from the embedded assets/landing.html when templateFile is empty.
+func newLandingHandler(templateFile string) (http.HandlerFunc, error) {
+	var tmpl *template.Template
+	var err error
+	if templateFile != "" {
+		tmpl, err = template.ParseFiles(templateFile)
+	} else {
+		tmpl, err = template.ParseFS(assetFS, "assets/landing.html")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := landingData{
+			Host:       r.Host,
+			WSEndpoint: "wss://" + r.Host + "/ws",
+			Version:    Version,
+		}
+
+		var page bytes.Buffer
+		if err := tmpl.Execute(&page, data); err != nil {
+			slog.Error("Landing page template error", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(page.Bytes())
+	}, nil
+}
+
+// pushAssets pushes the configured assets along with the landing page to
+// HTTP/2 clients that accept server push, saving a round trip before the
+// page can render
+func (s *Server) pushAssets(w http.ResponseWriter, r *http.Request) {
+	pusher, ok := w.(http.Pusher)
+	if !ok {
+		return
+	}
+
+	options := &http.PushOptions{Header: http.Header{}}
+	if encoding := r.Header.Get("Accept-Encoding"); encoding != "" {
+		options.Header.Set("Accept-Encoding", encoding)
+	}
+	for _, path := range s.config.LandingPush {
+		if err := pusher.Push(path, options); err != nil {
+			if err != http.ErrNotSupported {
+				slog.Debug("Failed to push asset", "path", path, "error", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+// TCPOptions are the socket options applied to every accepted connection
+type TCPOptions struct {
+	KeepAlive bool
+	// KeepIdle is the time before the first probe (TCP_KEEPIDLE)
+	KeepIdle time.Duration
+	// KeepInterval is the time between probes (TCP_KEEPINTVL)
+	KeepInterval time.Duration
+	// KeepCount is the number of unanswered probes before the connection
+	// is dropped (TCP_KEEPCNT), 0 keeps the OS default
+	KeepCount int
+	// Linger is the SO_LINGER timeout in seconds, negative keeps the OS
+	// default of closing in the background
+	Linger int
+}
+
+// DefaultTCPOptions match Go's default keepalive
+var DefaultTCPOptions = TCPOptions{
+	KeepAlive:    true,
+	KeepIdle:     15 * time.Second,
+	KeepInterval: 15 * time.Second,
+	Linger:       -1,
+}
+
+type tunedListener struct {
+	net.Listener
+	options TCPOptions
+}
+
+// TuneListener applies options to the connections accepted by ln, e.g. a
+// listener inherited from a previous process
+func TuneListener(ln net.Listener, options TCPOptions) net.Listener {
+	return &tunedListener{Listener: ln, options: options}
+}
+
+func (l *tunedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		l.options.apply(tcpConn)
+	}
+	return newTimedConn(conn), nil
+}
+
+// File returns a copy of the underlying socket, for handing it over to
+// another process
+func (l *tunedListener) File() (*os.File, error) {
+	tcpListener, ok := l.Listener.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("cannot hand over %T", l.Listener)
+	}
+	return tcpListener.File()
+}
+
+func (o TCPOptions) apply(conn *net.TCPConn) {
+	if o.Linger >= 0 {
+		conn.SetLinger(o.Linger)
+	}
+
+	if !o.KeepAlive {
+		conn.SetKeepAlive(false)
+		return
+	}
+
+	conn.SetKeepAlive(true)
+	if err := setKeepAliveParams(conn, o.KeepIdle, o.KeepInterval, o.KeepCount); err != nil {
+		slog.Warn("Failed to tune TCP keepalive", "remote_addr", conn.RemoteAddr().String(), "error", err)
+	}
+}
+
+// Listen opens a TCP listener whose accepted connections get the given
+// options instead of Go's default 15s keepalive. With reusePort several
+// listeners may share the address.
+func Listen(address string, options TCPOptions, reusePort bool) (net.Listener, error) {
+	lc := net.ListenConfig{
+		// Keepalive is configured per connection in tunedListener
+		KeepAlive: -1,
+	}
+	if reusePort {
+		lc.Control = setReusePort
+	}
+
+	ln, err := lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return TuneListener(ln, options), nil
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a scheduled maintenance interval
+type MaintenanceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// maintenanceSchedule decides whether pings are answered with status
+// "maintenance" instead of "ok"
+type maintenanceSchedule struct {
+	forced  bool
+	flag    string
+	windows []MaintenanceWindow
+}
+
+func newMaintenanceSchedule(config Config) *maintenanceSchedule {
+	return &maintenanceSchedule{
+		forced:  config.MaintenanceMode,
+		flag:    config.MaintenanceFile,
+		windows: config.MaintenanceWindows,
+	}
+}
+
+// ParseMaintenanceWindows parses a comma-separated list of RFC 3339
+// intervals such as "2024-01-15T01:00:00Z/2024-01-15T03:00:00Z"
+func ParseMaintenanceWindows(value string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	for _, interval := range strings.Split(value, ",") {
+		interval = strings.TrimSpace(interval)
+		if interval == "" {
+			continue
+		}
+
+		bounds := strings.Split(interval, "/")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid window %q: expected start/end", interval)
+		}
+		start, err := time.Parse(time.RFC3339, bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window start %q: %w", bounds[0], err)
+		}
+		end, err := time.Parse(time.RFC3339, bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window end %q: %w", bounds[1], err)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("invalid window %q: end before start", interval)
+		}
+
+		windows = append(windows, MaintenanceWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// active reports whether maintenance is on: forced by configuration,
+// toggled by the presence of the flag file, or inside a scheduled window
+func (m *maintenanceSchedule) active(now time.Time) bool {
+	if m.forced {
+		return true
+	}
+
+	if m.flag != "" {
+		if _, err := os.Stat(m.flag); err == nil {
+			return true
+		}
+	}
+
+	for _, window := range m.windows {
+		if !now.Before(window.Start) && now.Before(window.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// maintenanceActive reports whether maintenance is on at now, by the
+// schedule or the admin toggle
+func (s *Server) maintenanceActive(now time.Time) bool {
+	return s.maintenanceToggle.Load() || s.maintenance.Load().active(now)
+}
+
+// inMaintenance reports whether maintenance is active; alerts are held
+// off meanwhile
+func (s *Server) inMaintenance() bool {
+	return s.maintenanceActive(s.clock.Now())
+}
+
+// maintenanceStatus is the body of /admin/maintenance
+type maintenanceStatus struct {
+	Active   bool                `json:"active"`
+	Toggled  bool                `json:"toggled"`
+	Forced   bool                `json:"forced"`
+	FlagFile bool                `json:"flag_file"`
+	Windows  []MaintenanceWindow `json:"windows"`
+}
+
+// handleAdminMaintenance reports maintenance: GET /admin/maintenance.
+// Operators switch the toggle with POST /admin/maintenance?active=true
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		withRole(RoleOperator, http.HandlerFunc(s.toggleMaintenance)).ServeHTTP(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.maintenanceStatus())
+}
+
+// maintenanceStatus reports the toggle, the configured sources and the
+// windows that haven't ended yet
+func (s *Server) maintenanceStatus() maintenanceStatus {
+	now := s.clock.Now()
+	schedule := s.maintenance.Load()
+	status := maintenanceStatus{
+		Active:  s.maintenanceActive(now),
+		Toggled: s.maintenanceToggle.Load(),
+		Forced:  schedule.forced,
+		Windows: []MaintenanceWindow{},
+	}
+	if schedule.flag != "" {
+		_, err := os.Stat(schedule.flag)
+		status.FlagFile = err == nil
+	}
+	for _, window := range schedule.windows {
+		if now.Before(window.End) {
+			status.Windows = append(status.Windows, window)
+		}
+	}
+	return status
+}
+
+// toggleMaintenance switches the admin toggle and reports the result
+func (s *Server) toggleMaintenance(w http.ResponseWriter, r *http.Request) {
+	active, err := strconv.ParseBool(r.URL.Query().Get("active"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_active"})
+		return
+	}
+
+	if previous := s.maintenanceToggle.Swap(active); previous != active {
+		slog.Info("Maintenance toggled", "active", active, "role", r.Context().Value(adminRoleKey{}))
+		if active {
+			s.events.record("maintenance", "Maintenance mode on", "toggled through the admin API")
+		} else {
+			s.events.record("maintenance", "Maintenance mode off", "toggled through the admin API")
+		}
+	}
+	writeJSON(w, http.StatusOK, s.maintenanceStatus())
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"ming-mong/client"
+	"ming-mong/server"
+)
+
+func TestAdminMaintenanceToggle(t *testing.T) {
+	config := server.DefaultConfig()
+	config.AdminTokens = map[string]server.AdminRole{"viewer-token": server.RoleViewer, "operator-token": server.RoleOperator}
+	ln := serveInMemory(t, config)
+	httpClient := &http.Client{Transport: &http.Transport{DialContext: ln.DialContext}}
+
+	toggle := func(token, active string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, "http://ming-mong.test/admin/maintenance?active="+active, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /admin/maintenance: %v", err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+	pingStatus := func() string {
+		t.Helper()
+		result, err := client.Ping(context.Background(), "ws://ming-mong.test/ws", client.Options{NetDialContext: ln.DialContext})
+		if err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+		return result.Status
+	}
+
+	if status, _ := toggle("viewer-token", "true"); status != http.StatusForbidden {
+		t.Fatalf("viewer toggled maintenance: status %d", status)
+	}
+	if status, _ := toggle("operator-token", "maybe"); status != http.StatusBadRequest {
+		t.Fatalf("got status %d for an invalid value, want 400", status)
+	}
+	if got := pingStatus(); got != "ok" {
+		t.Fatalf("got status %q before the toggle, want ok", got)
+	}
+
+	status, body := toggle("operator-token", "true")
+	if status != http.StatusOK || body["active"] != true || body["toggled"] != true {
+		t.Fatalf("got %d %v", status, body)
+	}
+	if got := pingStatus(); got != "maintenance" {
+		t.Fatalf("got status %q while toggled, want maintenance", got)
+	}
+
+	toggle("operator-token", "false")
+	if got := pingStatus(); got != "ok" {
+		t.Fatalf("got status %q after switching off, want ok", got)
+	}
+}
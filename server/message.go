@@ -0,0 +1,25 @@
+package server
+
+import (
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+// readLimitedMessage reads the next message but buffers at most limit
+// bytes of it, so oversized frames are rejected before JSON parsing
+func readLimitedMessage(conn *websocket.Conn, limit int64) ([]byte, error) {
+	_, reader, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrOversizedMessage
+	}
+	return data, nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// allowMethods passes only requests using one of methods on to handler and
+// rejects the rest with rejectMethod
+func (s *Server) allowMethods(handler http.Handler, methods ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		s.rejectMethod(w, methods...)
+	})
+}
+
+// rejectMethod answers a request with the wrong method on an existing
+// endpoint: a connection drop like unknown paths by default, or a proper
+// 405 with an Allow header for WAFs and load balancers that treat resets as
+// backend failures.
+func (s *Server) rejectMethod(w http.ResponseWriter, allowed ...string) {
+	if !s.config.MethodNotAllowed {
+		dropConnection(w)
+		return
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
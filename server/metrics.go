@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pingMetrics counts ping exchanges by result and tag for the Prometheus
+// endpoint. Tags are mapped to the bounded set tracked by tagStats.
+type pingMetrics struct {
+	mu      sync.Mutex
+	results map[[2]string]uint64
+	latency *histogram
+}
+
+func newPingMetrics() *pingMetrics {
+	return &pingMetrics{results: make(map[[2]string]uint64), latency: newHistogram()}
+}
+
+func (m *pingMetrics) observe(result, tag string, latency time.Duration) {
+	m.mu.Lock()
+	m.results[[2]string{result, tag}]++
+	m.mu.Unlock()
+	m.latency.observe(latency)
+}
+
+// newMetricsHandler serves metrics in the Prometheus text format. With a
+// token set, requests without it get their connection dropped.
+func (s *Server) newMetricsHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			supplied := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) != 1 {
+				dropConnection(w)
+				return
+			}
+		}
+		if r.Method != http.MethodGet {
+			s.rejectMethod(w, http.MethodGet)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		s.writeMetrics(w)
+	}
+}
+
+func (s *Server) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP ming_mong_build_info Build information\n")
+	fmt.Fprintf(w, "# TYPE ming_mong_build_info gauge\n")
+	fmt.Fprintf(w, "ming_mong_build_info{version=%s} 1\n", labelValue(Version))
+
+	s.metrics.mu.Lock()
+	keys := make([][2]string, 0, len(s.metrics.results))
+	for key := range s.metrics.results {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	fmt.Fprintf(w, "# HELP ming_mong_pings_total WebSocket exchanges by result (ok, invalid_signature, invalid_format, upgrade_failed, ...) and connection tag\n")
+	fmt.Fprintf(w, "# TYPE ming_mong_pings_total counter\n")
+	for _, key := range keys {
+		fmt.Fprintf(w, "ming_mong_pings_total{result=%s,tag=%s} %d\n",
+			labelValue(key[0]), labelValue(key[1]), s.metrics.results[key])
+	}
+	s.metrics.mu.Unlock()
+
+	writeHistogram(w, "ming_mong_handler_duration_seconds", "Time from accepting a WebSocket request to answering its ping", s.metrics.latency.snapshot())
+	writeHistogram(w, "ming_mong_tls_handshake_seconds", "TLS handshake duration", s.timings.tlsHandshake.snapshot())
+	writeHistogram(w, "ming_mong_upgrade_seconds", "Time from the connection being ready to the WebSocket upgrade", s.timings.upgrade.snapshot())
+	writeHistogram(w, "ming_mong_first_message_seconds", "Time from the WebSocket upgrade to the client's first message", s.timings.firstMessage.snapshot())
+
+	fmt.Fprintf(w, "# HELP ming_mong_live_connections Open WebSocket connections\n")
+	fmt.Fprintf(w, "# TYPE ming_mong_live_connections gauge\n")
+	fmt.Fprintf(w, "ming_mong_live_connections %d\n", s.stats.live())
+
+	if s.admission != nil {
+		s.admission.mu.Lock()
+		fmt.Fprintf(w, "# HELP ming_mong_admitted_requests Requests holding a slot of MAX_CONNECTIONS by admission class\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_admitted_requests gauge\n")
+		for _, class := range []admissionClass{classAnonymous, classPriority} {
+			fmt.Fprintf(w, "ming_mong_admitted_requests{class=%s} %d\n", labelValue(class.String()), s.admission.active[class])
+		}
+		fmt.Fprintf(w, "# HELP ming_mong_admission_rejected_total Requests answered with 503 because their admission class was full\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_admission_rejected_total counter\n")
+		for _, class := range []admissionClass{classAnonymous, classPriority} {
+			fmt.Fprintf(w, "ming_mong_admission_rejected_total{class=%s} %d\n", labelValue(class.String()), s.admission.rejected[class])
+		}
+		s.admission.mu.Unlock()
+	}
+
+	if s.bans != nil {
+		fmt.Fprintf(w, "# HELP ming_mong_banned_ips Client IPs whose traffic is dropped after repeated invalid signatures\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_banned_ips gauge\n")
+		fmt.Fprintf(w, "ming_mong_banned_ips %d\n", s.bans.active(s.clock.Now()))
+		s.bans.mu.Lock()
+		fmt.Fprintf(w, "# HELP ming_mong_bans_total Bans started after repeated invalid signatures\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_bans_total counter\n")
+		fmt.Fprintf(w, "ming_mong_bans_total %d\n", s.bans.total)
+		s.bans.mu.Unlock()
+	}
+
+	if s.authHook != nil {
+		fmt.Fprintf(w, "# HELP ming_mong_auth_hook_saturated_total Pings denied because AUTH_HOOK_CONCURRENCY hooks were already running\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_auth_hook_saturated_total counter\n")
+		fmt.Fprintf(w, "ming_mong_auth_hook_saturated_total %d\n", s.authHook.saturated.Load())
+	}
+
+	if s.watchdog != nil {
+		s.watchdog.mu.Lock()
+		fmt.Fprintf(w, "# HELP ming_mong_watchdog_stalls_total Times the whole process was not scheduled for longer than 2s\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_watchdog_stalls_total counter\n")
+		fmt.Fprintf(w, "ming_mong_watchdog_stalls_total %d\n", s.watchdog.stalls)
+		fmt.Fprintf(w, "# HELP ming_mong_watchdog_stuck_total Requests and ping exchanges that ran longer than WATCHDOG_TIMEOUT\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_watchdog_stuck_total counter\n")
+		fmt.Fprintf(w, "ming_mong_watchdog_stuck_total %d\n", s.watchdog.stuck)
+		s.watchdog.mu.Unlock()
+	}
+
+	if len(s.slos) > 0 {
+		statuses := s.sloStatuses(s.clock.Now())
+		fmt.Fprintf(w, "# HELP ming_mong_slo_burn_rate Error budget burn rate of each SLO by alert window; 1 spends the budget by the end of the SLO window\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_slo_burn_rate gauge\n")
+		for _, status := range statuses {
+			windows := make([]string, 0, len(status.BurnRates))
+			for window := range status.BurnRates {
+				windows = append(windows, window)
+			}
+			sort.Strings(windows)
+			for _, window := range windows {
+				fmt.Fprintf(w, "ming_mong_slo_burn_rate{slo=%s,window=%s} %s\n", labelValue(status.Name), labelValue(window),
+					strconv.FormatFloat(status.BurnRates[window], 'g', -1, 64))
+			}
+		}
+		fmt.Fprintf(w, "# HELP ming_mong_slo_error_budget_remaining Share of the error budget left in the SLO window\n")
+		fmt.Fprintf(w, "# TYPE ming_mong_slo_error_budget_remaining gauge\n")
+		for _, status := range statuses {
+			fmt.Fprintf(w, "ming_mong_slo_error_budget_remaining{slo=%s} %s\n", labelValue(status.Name),
+				strconv.FormatFloat(status.BudgetRemaining, 'g', -1, 64))
+		}
+	}
+
+	level, _ := s.health.current()
+	fmt.Fprintf(w, "# HELP ming_mong_health Overall health: 0 ok, 1 degraded, 2 failing\n")
+	fmt.Fprintf(w, "# TYPE ming_mong_health gauge\n")
+	fmt.Fprintf(w, "ming_mong_health %d\n", level)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue quotes a label value for the text format
+func labelValue(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+// writeHistogram converts a millisecond histogram to a Prometheus one in
+// seconds
+func writeHistogram(w io.Writer, name, help string, snapshot histogramSnapshot) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for _, bucket := range snapshot.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bucket.LeMs/1000, 'g', -1, 64), bucket.Count)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, snapshot.Count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(snapshot.SumMs/1000, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, snapshot.Count)
+}
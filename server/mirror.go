@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pingMirror replays a sample of incoming pings against a secondary
+// instance (shadow traffic) and logs when it answers differently, so a new
+// version can be validated against real clients before cutover. Mirroring
+// never delays or affects the primary response.
+type pingMirror struct {
+	url      string
+	rate     float64
+	dialer   *websocket.Dialer
+	requests chan mirrorRequest
+
+	mu         sync.Mutex
+	mirrored   uint64
+	mismatches uint64
+	failures   uint64
+}
+
+type mirrorRequest struct {
+	message   []byte
+	result    string
+	clientIP  string
+	userAgent string
+}
+
+// mirrorWorkers bounds the concurrent connections to the secondary
+const mirrorWorkers = 4
+
+func newPingMirror(target string, rate float64, insecure bool, proxy func(*http.Request) (*url.URL, error), dialer Dialer) *pingMirror {
+	mirror := &pingMirror{
+		url:  target,
+		rate: rate,
+		dialer: &websocket.Dialer{
+			Proxy:            proxy,
+			HandshakeTimeout: 5 * time.Second,
+			TLSClientConfig:  &tls.Config{InsecureSkipVerify: insecure},
+		},
+		requests: make(chan mirrorRequest, 256),
+	}
+	if dialer != nil {
+		mirror.dialer.NetDialContext = dialer.DialContext
+	}
+	return mirror
+}
+
+// submit queues a ping for mirroring if it is sampled; requests are dropped
+// when the secondary can't keep up
+func (m *pingMirror) submit(request mirrorRequest) {
+	if rand.Float64() >= m.rate {
+		return
+	}
+	select {
+	case m.requests <- request:
+	default:
+	}
+}
+
+func (m *pingMirror) run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < mirrorWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case request := <-m.requests:
+					m.replay(request)
+				}
+			}
+		}()
+	}
+	workers.Wait()
+}
+
+// replay sends the message to the secondary and compares its outcome
+func (m *pingMirror) replay(request mirrorRequest) {
+	header := http.Header{}
+	header.Set("X-Forwarded-For", request.clientIP)
+	header.Set("User-Agent", request.userAgent)
+
+	result, err := m.exchange(request.message, header)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mirrored++
+	if err != nil {
+		m.failures++
+		slog.Warn("Mirror request failed", "url", m.url, "error", err)
+		return
+	}
+	if result != request.result {
+		m.mismatches++
+		slog.Warn("Mirror mismatch", "client_ip", request.clientIP, "primary", request.result, "secondary", result)
+	}
+}
+
+func (m *pingMirror) exchange(message []byte, header http.Header) (string, error) {
+	conn, _, err := m.dialer.Dial(m.url, header)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		return "", err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
+
+	var reply PongMessage
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return "", err
+	}
+	// Results are named as in the exchange logs: error codes, "ok" for a
+	// pong and the message type otherwise, such as "time"
+	switch reply.Type {
+	case "error":
+		return reply.Error, nil
+	case "pong":
+		return "ok", nil
+	}
+	return reply.Type, nil
+}
+
+// stats reports how many pings were mirrored and how they compared
+func (m *pingMirror) stats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return map[string]interface{}{
+		"url":        m.url,
+		"rate":       m.rate,
+		"mirrored":   m.mirrored,
+		"mismatches": m.mismatches,
+		"failures":   m.failures,
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxNonceLength bounds the nonces kept in memory; 32 random bytes in hex
+// fit
+const maxNonceLength = 64
+
+// maxNonces bounds the nonces remembered across both generations. Anyone
+// holding a signing key, including the public built-in secret, can sign
+// fresh nonces, so without a bound they could fill memory for two days.
+const maxNonces = 1 << 20
+
+// nonceCache remembers nonces for at least ttl in two generations: the
+// current one is retired after ttl and dropped after another, so memory
+// follows the traffic of the last two windows without per-entry expiry.
+type nonceCache struct {
+	mu       sync.Mutex
+	current  map[string]struct{}
+	previous map[string]struct{}
+	rotated  time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{current: make(map[string]struct{})}
+}
+
+// record remembers nonce, failing with ErrReplayed if it was already
+// recorded within ttl. Once maxNonces are remembered new nonces fail with
+// ErrRateLimited until a generation is dropped; forgetting nonces early
+// instead would let a flood reopen captured pings to replay.
+func (c *nonceCache) record(nonce string, now time.Time, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.rotated) >= ttl {
+		c.previous, c.current = c.current, make(map[string]struct{})
+		c.rotated = now
+	}
+	if _, ok := c.current[nonce]; ok {
+		return fmt.Errorf("%w: %s", ErrReplayed, nonce)
+	}
+	if _, ok := c.previous[nonce]; ok {
+		return fmt.Errorf("%w: %s", ErrReplayed, nonce)
+	}
+	if len(c.current)+len(c.previous) >= maxNonces {
+		return fmt.Errorf("%w: nonce cache full", ErrRateLimited)
+	}
+	c.current[nonce] = struct{}{}
+	return nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNonceCacheReplay(t *testing.T) {
+	cache := newNonceCache()
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	ttl := time.Hour
+
+	if err := cache.record("a", now, ttl); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := cache.record("a", now.Add(time.Minute), ttl); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("got %v, want ErrReplayed", err)
+	}
+	// Retired to the previous generation, it is still remembered
+	if err := cache.record("a", now.Add(ttl+time.Minute), ttl); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("got %v after one rotation, want ErrReplayed", err)
+	}
+	if err := cache.record("a", now.Add(3*ttl), ttl); err != nil {
+		t.Fatalf("after two rotations: %v", err)
+	}
+}
+
+func TestNonceCacheFull(t *testing.T) {
+	cache := newNonceCache()
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	ttl := time.Hour
+	for i := 0; i < maxNonces; i++ {
+		if err := cache.record(strconv.Itoa(i), now, ttl); err != nil {
+			t.Fatalf("nonce %d: %v", i, err)
+		}
+	}
+
+	if err := cache.record("one more", now, ttl); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v with a full cache, want ErrRateLimited", err)
+	}
+	// Replays are still told apart from a full cache
+	if err := cache.record("0", now, ttl); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("got %v, want ErrReplayed", err)
+	}
+	// The full generation is only retired after ttl, and dropped after
+	// another
+	if err := cache.record("one more", now.Add(ttl), ttl); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got %v with the full generation retired, want ErrRateLimited", err)
+	}
+	if err := cache.record("one more", now.Add(2*ttl), ttl); err != nil {
+		t.Fatalf("after the oldest generation was dropped: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ParseProxyURL reads an outbound proxy: http://, https://, socks5:// or
+// socks5h://, optionally with user:password. Errors leave out the value
+// so credentials don't end up in logs.
+func ParseProxyURL(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.New("malformed proxy URL")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("proxy URL without host")
+	}
+	return u, nil
+}
+
+// proxiedTransport is the default transport with proxy replaced, and its
+// dialer when one is set
+func proxiedTransport(proxy func(*http.Request) (*url.URL, error), dialer Dialer) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	if dialer != nil {
+		transport.DialContext = dialer.DialContext
+	}
+	return transport
+}
+
+// proxyFunc routes outbound requests through the first proxy set, or the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables otherwise
+func proxyFunc(proxies ...*url.URL) func(*http.Request) (*url.URL, error) {
+	for _, proxy := range proxies {
+		if proxy != nil {
+			return http.ProxyURL(proxy)
+		}
+	}
+	return http.ProxyFromEnvironment
+}
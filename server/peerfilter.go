@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseNetworks reads a comma-separated list of CIDRs and single
+// addresses; "localhost" stands for the loopback ranges
+func ParseNetworks(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+			continue
+		case strings.EqualFold(part, "localhost"):
+			part = "127.0.0.0/8,::1/128"
+		case !strings.Contains(part, "/"):
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", part)
+			}
+			if ip.To4() != nil {
+				part += "/32"
+			} else {
+				part += "/128"
+			}
+		}
+		for _, cidr := range strings.Split(part, ",") {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", cidr)
+			}
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+// containsAddr reports whether addr is in one of networks
+func containsAddr(networks []*net.IPNet, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	ip := net.IP(addr.AsSlice())
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerPermitted applies AllowCIDRs and DenyCIDRs to the socket address;
+// proxy headers are ignored since they could be forged. A peer in both
+// lists is denied.
+func (s *Server) peerPermitted(r *http.Request) bool {
+	allow, deny := s.config.AllowCIDRs, s.config.DenyCIDRs
+	if len(allow) == 0 && len(deny) == 0 {
+		return true
+	}
+	addr := socketAddr(r)
+	if containsAddr(deny, addr) || (len(allow) > 0 && !containsAddr(allow, addr)) {
+		slog.Debug("Peer not permitted", "remote_addr", r.RemoteAddr)
+		return false
+	}
+	return true
+}
+
+// withPeerFilter drops connections from peers outside AllowCIDRs or inside
+// DenyCIDRs before handler sees them, so no WebSocket upgrade happens
+func (s *Server) withPeerFilter(handler http.Handler) http.Handler {
+	if len(s.config.AllowCIDRs) == 0 && len(s.config.DenyCIDRs) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.peerPermitted(r) {
+			dropConnection(w)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
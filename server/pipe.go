@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// Dialer opens the server's outbound connections: the ClickHouse sink,
+// the ping mirror and crash reports. *net.Dialer and PipeListener satisfy
+// it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// PipeListener is an in-memory net.Listener for tests: pass it to
+// Server.Serve and dial it instead of a port. Connections are net.Pipe
+// pairs, so they support deadlines but no TCP options.
+type PipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+	nextPort  atomic.Uint32
+}
+
+// pipeListenerAddr is the address the listener claims to listen on
+var pipeListenerAddr = net.TCPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:8443"))
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return pipeListenerAddr
+}
+
+// DialContext connects to the listener from a new loopback port; network
+// and address are ignored, so it can stand in for a net.Dialer anywhere
+func (l *PipeListener) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	port := 32768 + l.nextPort.Add(1)%28232
+	return l.DialFrom(ctx, netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), uint16(port)))
+}
+
+// DialFrom connects to the listener as if from the client address from, to
+// test per-client behavior such as rate limits, bans and peer filters
+func (l *PipeListener) DialFrom(ctx context.Context, from netip.AddrPort) (net.Conn, error) {
+	server, client := net.Pipe()
+	remote := net.TCPAddrFromAddrPort(from)
+	select {
+	case l.conns <- &pipeConn{Conn: server, local: pipeListenerAddr, remote: remote}:
+		return &pipeConn{Conn: client, local: remote, remote: pipeListenerAddr}, nil
+	case <-ctx.Done():
+		server.Close()
+		client.Close()
+		return nil, ctx.Err()
+	case <-l.closed:
+		server.Close()
+		client.Close()
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeListenerAddr, Err: net.ErrClosed}
+	}
+}
+
+// pipeConn gives one end of a net.Pipe TCP addresses, as the server
+// derives client IPs from them
+type pipeConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return c.local }
+func (c *pipeConn) RemoteAddr() net.Addr { return c.remote }
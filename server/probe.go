@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	probeMaxSteps    = 16
+	probeStepTimeout = 2 * time.Second
+)
+
+// ProbeFrame is sent once per requested size; its JSON encoding is padded
+// to exactly Size bytes
+type ProbeFrame struct {
+	Type    string `json:"type"`
+	Seq     int    `json:"seq"`
+	Size    int    `json:"size"`
+	Padding string `json:"padding"`
+}
+
+type ProbeAck struct {
+	Type string `json:"type"`
+	Seq  int    `json:"seq"`
+}
+
+type ProbeResult struct {
+	Type         string `json:"type"`
+	Acknowledged []int  `json:"acknowledged"`
+	Failed       []int  `json:"failed"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// runProbe sends frames of increasing size and waits for the client to
+// acknowledge each one. The first unacknowledged size stops the probe,
+// since a black-holed frame usually stalls the whole stream behind it.
+func runProbe(ctx context.Context, conn *websocket.Conn, sizes []int, clientIP string, clock Clock) ProbeResult {
+	result := ProbeResult{Type: "probe_result", Acknowledged: []int{}, Failed: []int{}}
+
+	for seq, size := range sizes {
+		if ctx.Err() != nil || !sendProbeFrame(conn, seq, size) || !awaitProbeAck(conn, seq) {
+			result.Failed = append(result.Failed, sizes[seq:]...)
+			break
+		}
+		result.Acknowledged = append(result.Acknowledged, size)
+	}
+
+	slog.Info("Probe finished", "client_ip", clientIP, "acknowledged", result.Acknowledged, "failed", result.Failed)
+	result.Timestamp = clock.Now().UTC().Format(time.RFC3339Nano)
+	return result
+}
+
+func sendProbeFrame(conn *websocket.Conn, seq, size int) bool {
+	frame := ProbeFrame{Type: "probe", Seq: seq, Size: size}
+	base, err := json.Marshal(frame)
+	if err != nil {
+		return false
+	}
+	if size > len(base) {
+		frame.Padding = strings.Repeat("x", size-len(base))
+	}
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return false
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(probeStepTimeout))
+	return conn.WriteMessage(websocket.TextMessage, data) == nil
+}
+
+func awaitProbeAck(conn *websocket.Conn, seq int) bool {
+	conn.SetReadDeadline(time.Now().Add(probeStepTimeout))
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return false
+	}
+
+	var ack ProbeAck
+	if err := json.Unmarshal(data, &ack); err != nil {
+		return false
+	}
+	return ack.Type == "probe_ack" && ack.Seq == seq
+}
+
+// validProbeSizes checks the requested sizes are ascending and within
+// maxSize, so probes can't be used for amplification
+func validProbeSizes(sizes []int, maxSize int) bool {
+	if len(sizes) == 0 || len(sizes) > probeMaxSteps {
+		return false
+	}
+	for i, size := range sizes {
+		if size <= 0 || size > maxSize {
+			return false
+		}
+		if i > 0 && size <= sizes[i-1] {
+			return false
+		}
+	}
+	return true
+}
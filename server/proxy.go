@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// forwardingHeaders are the request headers proxies, load balancers and
+// CDNs add on the way to the server
+var forwardingHeaders = []string{
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"Cdn-Loop",
+	"Cf-Connecting-Ip",
+	"Cf-Ray",
+	"Fastly-Client-Ip",
+	"True-Client-Ip",
+	"X-Amzn-Trace-Id",
+	"X-Azure-Ref",
+}
+
+// ProxyInfo describes the intermediaries between client and server, to
+// tell whether latency or failures come from a CDN or load balancer layer
+type ProxyInfo struct {
+	// Headers lists the forwarding headers present on the request
+	Headers []string `json:"headers"`
+	// Hops is the number of proxies detected, the longest of the Via,
+	// Forwarded and X-Forwarded-For chains
+	Hops int `json:"hops"`
+	// Via lists the proxies that identified themselves, e.g. "1.1 varnish"
+	Via []string `json:"via,omitempty"`
+}
+
+// proxyInfo inspects the forwarding headers of r
+func proxyInfo(r *http.Request) *ProxyInfo {
+	info := &ProxyInfo{Headers: []string{}}
+	for _, name := range forwardingHeaders {
+		if _, ok := r.Header[name]; ok {
+			info.Headers = append(info.Headers, name)
+		}
+	}
+
+	info.Via = headerList(r.Header.Values("Via"))
+	for _, chain := range [][]string{
+		info.Via,
+		headerList(r.Header.Values("Forwarded")),
+		headerList(r.Header.Values("X-Forwarded-For")),
+	} {
+		if len(chain) > info.Hops {
+			info.Hops = len(chain)
+		}
+	}
+	return info
+}
+
+// headerList splits comma-separated header values into their elements,
+// keeping commas inside quoted strings
+func headerList(values []string) []string {
+	var elements []string
+	for _, value := range values {
+		inQuotes := false
+		start := 0
+		for i := 0; i <= len(value); i++ {
+			if i < len(value) {
+				switch value[i] {
+				case '"':
+					inQuotes = !inQuotes
+					continue
+				case '\\':
+					if inQuotes {
+						i++
+					}
+					continue
+				case ',':
+					if inQuotes {
+						continue
+					}
+				default:
+					continue
+				}
+			}
+			if element := strings.TrimSpace(value[start:i]); element != "" {
+				elements = append(elements, element)
+			}
+			start = i + 1
+		}
+	}
+	return elements
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RateLimiter decides whether a client may open another connection to /ws.
+// Key is the client IP. Implementations must be safe for concurrent use; an
+// error lets the connection through and reports degraded health, so an
+// unreachable backend doesn't take pings down with it.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// MemoryRateLimiter allows limit connections per key in fixed windows,
+// counted in process memory. Windows follow Config.Clock of the server it's
+// given to.
+type MemoryRateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	clock     Clock
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewMemoryRateLimiter allows limit connections per key every window
+func NewMemoryRateLimiter(limit int, window time.Duration) *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		limit:     limit,
+		window:    window,
+		clock:     systemClock{},
+		windows:   make(map[string]*rateWindow),
+		lastSweep: time.Now(),
+	}
+}
+
+// setClock moves the windows onto clock, starting them afresh
+func (l *MemoryRateLimiter) setClock(clock Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = clock
+	l.windows = make(map[string]*rateWindow)
+	l.lastSweep = clock.Now()
+}
+
+func (l *MemoryRateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	// Forget keys whose window has passed, bounding memory to the keys
+	// seen within the last two windows
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= l.limit, nil
+}
+
+// checkRateLimit consults the configured limiter for clientIP
+func (s *Server) checkRateLimit(ctx context.Context, clientIP string) error {
+	if s.config.RateLimiter == nil {
+		return nil
+	}
+
+	allowed, err := s.config.RateLimiter.Allow(ctx, clientIP)
+	if err != nil {
+		if !s.rateLimitFailing.Swap(true) {
+			slog.Error("Rate limiter failed, allowing connections", "error", err)
+		}
+		s.health.set("ratelimit", HealthDegraded, err.Error())
+		return nil
+	}
+	if s.rateLimitFailing.Swap(false) {
+		slog.Info("Rate limiter recovered")
+		s.health.clear("ratelimit")
+	}
+
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrRateLimited, clientIP)
+	}
+	return nil
+}
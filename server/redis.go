@@ -0,0 +1,171 @@
+//go:build !no_redis && !minimal
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitScript counts a connection and starts the window on the first one
+const rateLimitScript = `local n = redis.call('INCR', KEYS[1])
+if n == 1 then redis.call('PEXPIRE', KEYS[1], ARGV[1]) end
+return n`
+
+// RedisRateLimiter allows limit connections per key in fixed windows,
+// counted in Redis so several instances share the limit
+type RedisRateLimiter struct {
+	address  string
+	password string
+	db       int
+	prefix   string
+	limit    int
+	window   time.Duration
+
+	// One connection serves all requests; it is re-dialed after errors
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisRateLimiter connects to the Redis server at rawURL
+// (redis://[:password@]host:port[/db]) lazily, on the first request
+func NewRedisRateLimiter(rawURL string, limit int, window time.Duration) (*RedisRateLimiter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("expected redis://host:port URL, got %q", rawURL)
+	}
+
+	l := &RedisRateLimiter{
+		address: u.Host,
+		prefix:  "ming-mong:ratelimit:",
+		limit:   limit,
+		window:  window,
+	}
+	if u.Port() == "" {
+		l.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if password, ok := u.User.Password(); ok {
+		l.password = password
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil || l.db < 0 {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return l, nil
+}
+
+func (l *RedisRateLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		if err := l.dial(ctx); err != nil {
+			return true, err
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	l.conn.SetDeadline(deadline)
+
+	reply, err := l.do("EVAL", rateLimitScript, "1", l.prefix+key,
+		strconv.FormatInt(l.window.Milliseconds(), 10))
+	if err != nil {
+		l.conn.Close()
+		l.conn = nil
+		return true, err
+	}
+	count, ok := reply.(int64)
+	if !ok {
+		return true, fmt.Errorf("unexpected reply %v", reply)
+	}
+	return count <= int64(l.limit), nil
+}
+
+func (l *RedisRateLimiter) dial(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", l.address)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	l.conn = conn
+	l.reader = bufio.NewReader(conn)
+
+	if l.password != "" {
+		if _, err := l.do("AUTH", l.password); err != nil {
+			conn.Close()
+			l.conn = nil
+			return err
+		}
+	}
+	if l.db != 0 {
+		if _, err := l.do("SELECT", strconv.Itoa(l.db)); err != nil {
+			conn.Close()
+			l.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// do sends a command in the RESP protocol and reads its reply
+func (l *RedisRateLimiter) do(args ...string) (interface{}, error) {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := l.conn.Write([]byte(command.String())); err != nil {
+		return nil, err
+	}
+	return readRESP(l.reader)
+}
+
+// readRESP reads a simple string, error, integer or bulk string reply
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	}
+	return nil, fmt.Errorf("unsupported reply %q", line)
+}
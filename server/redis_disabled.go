@@ -0,0 +1,23 @@
+//go:build no_redis || minimal
+
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errRedisNotBuilt = errors.New("Redis support not built in (no_redis or minimal build tag)")
+
+// RedisRateLimiter is unavailable in builds with the no_redis or minimal tag
+type RedisRateLimiter struct{}
+
+// NewRedisRateLimiter always fails without Redis support
+func NewRedisRateLimiter(string, int, time.Duration) (*RedisRateLimiter, error) {
+	return nil, errRedisNotBuilt
+}
+
+func (*RedisRateLimiter) Allow(context.Context, string) (bool, error) {
+	return false, errRedisNotBuilt
+}
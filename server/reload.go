@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Reload applies the settings that can change while serving: the signing
+// and client keys, unkeyed signatures, secret and day offsets, and the
+// maintenance settings. The certificate files are re-read at once rather
+// than at the next CertReloadInterval. Other fields of config are ignored;
+// changing them takes a restart.
+func (s *Server) Reload(config Config) error {
+	if !config.UnkeyedSignatures && len(config.SigningKeys) == 0 && len(config.ClientKeys) == 0 {
+		return fmt.Errorf("unkeyed signatures disabled without signing or client keys")
+	}
+
+	if s.certs != nil {
+		changed, err := s.certs.reload()
+		if err != nil {
+			return fmt.Errorf("reload TLS certificate: %w", err)
+		}
+		s.health.clear("tls_reload")
+		if changed {
+			slog.Info("TLS certificate reloaded", "file", s.certs.certFile)
+		}
+	}
+
+	// Lets the auth failure log be rotated by renaming it
+	if s.authLog != nil {
+		if err := s.authLog.reopen(); err != nil {
+			return err
+		}
+	}
+
+	s.signatures.Store(newSignatureVerifier(config))
+	if previous := s.maintenance.Swap(newMaintenanceSchedule(config)); previous.forced != config.MaintenanceMode {
+		if config.MaintenanceMode {
+			s.events.record("maintenance", "Maintenance mode on", "")
+		} else {
+			s.events.record("maintenance", "Maintenance mode off", "")
+		}
+	}
+	s.events.record("reload", "Configuration reloaded", "")
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Other systems either lack SO_REUSEPORT or don't balance accepts with it
+const ReusePortSupported = false
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT load balancing is only supported on Linux")
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// AdminRole is what an admin token may do; each role includes the ones
+// below it
+type AdminRole int
+
+const (
+	// RoleViewer reads statistics
+	RoleViewer AdminRole = iota + 1
+	// RoleOperator may also change the running server, such as toggling
+	// maintenance
+	RoleOperator
+	// RoleAdmin may also see secrets, such as the expected signatures
+	RoleAdmin
+)
+
+var roleNames = map[AdminRole]string{
+	RoleViewer:   "viewer",
+	RoleOperator: "operator",
+	RoleAdmin:    "admin",
+}
+
+func (r AdminRole) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("AdminRole(%d)", int(r))
+}
+
+// ParseAdminRole reads a role name
+func ParseAdminRole(name string) (AdminRole, error) {
+	for role, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, expected viewer, operator or admin", name)
+}
+
+// ParseAdminTokens reads a comma-separated list of role:token pairs
+func ParseAdminTokens(value string) (map[string]AdminRole, error) {
+	tokens := make(map[string]AdminRole)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(part, ":")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("invalid token %q, expected role:token", part)
+		}
+		role, err := ParseAdminRole(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := tokens[token]; exists {
+			return nil, fmt.Errorf("duplicate token for role %s", name)
+		}
+		tokens[token] = role
+	}
+	return tokens, nil
+}
+
+// roleFor returns the role of the bearer token supplied, or 0 if it is
+// unknown. Every token is compared, so the time taken doesn't reveal which
+// one matched.
+func roleFor(tokens map[string]AdminRole, supplied string) AdminRole {
+	var found AdminRole
+	for token, role := range tokens {
+		if subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) == 1 {
+			found = role
+		}
+	}
+	return found
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// droppedReportInterval is how often sinks log the samples their full
+// queues dropped
+const droppedReportInterval = 10 * time.Second
+
+// PingSample describes the outcome of a single ping exchange
+type PingSample struct {
+	Client    string    `json:"client"`
+	IP        string    `json:"ip"`
+	LatencyMs float64   `json:"latency_ms"`
+	Result    string    `json:"result"`
+	Timestamp time.Time `json:"ts"`
+	// Tag is the tag the client attached with /ws?tag=
+	Tag string `json:"tag,omitempty"`
+}
+
+// SampleSink receives a sample for every ping exchange. Record is called
+// from the connection handler and must not block.
+type SampleSink interface {
+	Record(sample PingSample)
+}
+
+func (s *Server) recordSample(sample PingSample) {
+	latency := time.Duration(sample.LatencyMs * float64(time.Millisecond))
+	s.metrics.observe(sample.Result, s.tags.label(sample.Tag), latency)
+	for _, sink := range s.sinks {
+		sink.Record(sample)
+	}
+}
+
+// droppedSamples counts the samples a full queue turned away, so an outage
+// of the destination logs one total per interval instead of a line per ping
+type droppedSamples struct {
+	count atomic.Int64
+}
+
+func (d *droppedSamples) add() {
+	d.count.Add(1)
+}
+
+// report logs the samples dropped since the last report, if any
+func (d *droppedSamples) report(msg string) {
+	if n := d.count.Swap(0); n > 0 {
+		slog.Warn(msg, "samples", n)
+	}
+}
+
+// reportEvery calls report every droppedReportInterval until ctx ends
+func (d *droppedSamples) reportEvery(ctx context.Context, msg string) {
+	ticker := time.NewTicker(droppedReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.report(msg)
+			return
+		case <-ticker.C:
+			d.report(msg)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/nacl/box"
+	"strings"
+)
+
+// SealedMessage carries a ping or its reply encrypted with NaCl box, for
+// deployments where TLS ends at an intermediary that must not read the
+// messages. Requests name the client's public key; replies are sealed to
+// it with a fresh nonce.
+type SealedMessage struct {
+	Type string `json:"type"`
+	// Key is the client's base64 public key, set on requests only
+	Key   string `json:"key,omitempty"`
+	Nonce string `json:"nonce"`
+	Box   string `json:"box"`
+}
+
+// messageSealer opens sealed requests and seals their replies
+type messageSealer struct {
+	privateKey [32]byte
+	publicKey  [32]byte
+	// clientKeys are the public keys clients may use, by name; any key is
+	// accepted when empty
+	clientKeys map[[32]byte]string
+	// required rejects plaintext messages
+	required bool
+}
+
+func newMessageSealer(config Config) (*messageSealer, error) {
+	privateKey, err := ecdh.X25519().NewPrivateKey(config.EncryptionKey[:])
+	if err != nil {
+		return nil, err
+	}
+	sealer := &messageSealer{
+		privateKey: *config.EncryptionKey,
+		clientKeys: make(map[[32]byte]string, len(config.EncryptionClientKeys)),
+		required:   config.EncryptionRequired,
+	}
+	for name, key := range config.EncryptionClientKeys {
+		sealer.clientKeys[key] = name
+	}
+	copy(sealer.publicKey[:], privateKey.PublicKey().Bytes())
+	return sealer, nil
+}
+
+// replyWriter sends replies to a client, sealed or not
+type replyWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// sealedReplies seals each reply to the client's key
+type sealedReplies struct {
+	conn      replyWriter
+	sharedKey [32]byte
+}
+
+func (r *sealedReplies) WriteMessage(messageType int, data []byte) error {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	sealed, err := json.Marshal(SealedMessage{
+		Type:  "sealed",
+		Nonce: base64.StdEncoding.EncodeToString(nonce[:]),
+		Box:   base64.StdEncoding.EncodeToString(box.SealAfterPrecomputation(nil, data, &nonce, &r.sharedKey)),
+	})
+	if err != nil {
+		return err
+	}
+	return r.conn.WriteMessage(messageType, sealed)
+}
+
+// open returns the plaintext of a sealed message and a writer sealing the
+// replies to it. Plaintext messages are passed through with conn as the
+// writer, unless encryption is required.
+func (m *messageSealer) open(conn replyWriter, data []byte) ([]byte, replyWriter, error) {
+	var sealed SealedMessage
+	if err := json.Unmarshal(data, &sealed); err != nil || sealed.Type != "sealed" {
+		if m.required {
+			return nil, conn, ErrEncryptionRequired
+		}
+		return data, conn, nil
+	}
+
+	clientKey, err := decodeKey(sealed.Key)
+	if err != nil {
+		return nil, conn, fmt.Errorf("%w: client key: %v", ErrUndecryptable, err)
+	}
+	if _, known := m.clientKeys[clientKey]; len(m.clientKeys) > 0 && !known {
+		return nil, conn, fmt.Errorf("%w: unknown client key", ErrUndecryptable)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != 24 {
+		return nil, conn, fmt.Errorf("%w: invalid nonce", ErrUndecryptable)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Box)
+	if err != nil {
+		return nil, conn, fmt.Errorf("%w: invalid box encoding", ErrUndecryptable)
+	}
+
+	replies := &sealedReplies{conn: conn}
+	box.Precompute(&replies.sharedKey, &clientKey, &m.privateKey)
+	plaintext, ok := box.OpenAfterPrecomputation(nil, ciphertext, (*[24]byte)(nonce), &replies.sharedKey)
+	if !ok {
+		return nil, conn, fmt.Errorf("%w: authentication failed", ErrUndecryptable)
+	}
+	return plaintext, replies, nil
+}
+
+// decodeKey reads a base64 Curve25519 key
+func decodeKey(value string) ([32]byte, error) {
+	var key [32]byte
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != len(key) {
+		return key, fmt.Errorf("expected 32 base64-encoded bytes")
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// ParseEncryptionKey reads a base64 private key as printed by
+// `ming-mong keygen`
+func ParseEncryptionKey(value string) (*[32]byte, error) {
+	key, err := decodeKey(strings.TrimSpace(value))
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ParseEncryptionClientKeys reads a comma-separated list of
+// name:public_key pairs
+func ParseEncryptionClientKeys(value string) (map[string][32]byte, error) {
+	keys := make(map[string][32]byte)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, encoded, ok := strings.Cut(part, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid client key %q, expected name:public_key", part)
+		}
+		if _, exists := keys[name]; exists {
+			return nil, fmt.Errorf("duplicate client key name %q", name)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("client key %q: %w", name, err)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// EncryptionPublicKey returns the base64 public key clients seal messages
+// to, or "" when encryption is off
+func (s *Server) EncryptionPublicKey() string {
+	if s.sealer == nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(s.sealer.publicKey[:])
+}
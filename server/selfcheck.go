@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// selfCheck detects the server's public addresses and verifies each one
+// accepts connections on port. Results are logged and unreachable
+// listeners are reported as degraded health.
+func selfCheck(port, ipv4URL, ipv6URL string, health *healthRegistry) {
+	if !waitForListener(port, 5*time.Second) {
+		slog.Warn("Self-check: local listener not accepting connections", "port", port)
+		return
+	}
+
+	families := []struct {
+		network string
+		url     string
+	}{
+		{"tcp4", ipv4URL},
+		{"tcp6", ipv6URL},
+	}
+
+	for _, family := range families {
+		if family.url == "" {
+			continue
+		}
+
+		ip, err := detectPublicIP(family.network, family.url)
+		if err != nil {
+			slog.Info("Self-check: no public address detected", "network", family.network, "error", err)
+			continue
+		}
+
+		address := net.JoinHostPort(ip, port)
+		conn, err := net.DialTimeout(family.network, address, 5*time.Second)
+		if err != nil {
+			// Hairpin NAT can also cause this, so it is a hint rather than proof
+			slog.Warn("Self-check: public address is NOT reachable", "address", address, "error", err)
+			health.set("selfcheck_"+family.network, HealthDegraded, address+" not reachable")
+			continue
+		}
+		conn.Close()
+		slog.Info("Self-check: public address is reachable", "address", address)
+	}
+}
+
+func waitForListener(port string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", port), time.Second)
+		if err == nil {
+			conn.Close()
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+// detectPublicIP asks an echo service such as api.ipify.org for the
+// address it sees, forcing the request over the given address family
+func detectPublicIP(network, url string) (string, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return "", err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("%s returned an invalid address", url)
+	}
+	return ip.String(), nil
+}
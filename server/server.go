@@ -0,0 +1,832 @@
+// Package server implements the ming-mong ping/pong service. It can run on
+// its own listeners with Run or Serve, or be mounted into an existing HTTP
+// server with Handler.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config configures a Server. Start from DefaultConfig, the zero value
+// disables most of the service.
+type Config struct {
+	// Addr is the address Run listens on
+	Addr string
+	// CertFile and KeyFile enable TLS when both are set
+	CertFile string
+	KeyFile  string
+	// ACMEDomains enables TLS with certificates obtained and renewed
+	// automatically from Let's Encrypt, or ACMEDirectoryURL when set; it
+	// excludes CertFile and KeyFile. The server must be reachable on port
+	// 443 of each domain.
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMECacheDir     string
+	ACMEDirectoryURL string
+	// CertReloadInterval is how often the certificate files are checked
+	// for changes and reloaded
+	CertReloadInterval time.Duration
+	// TCP is applied to connections accepted by Run
+	TCP TCPOptions
+	// HandshakeTimeout bounds the time from accept to a complete request
+	// (including the TLS handshake) and writing the upgrade response
+	HandshakeTimeout time.Duration
+	// IdleTimeout is how long idle keep-alive connections are kept open
+	IdleTimeout time.Duration
+	// DrainTimeout is how long in-flight connections may take to finish
+	// when the Run or Serve context is cancelled
+	DrainTimeout time.Duration
+
+	// SignatureDayOffsets lists the accepted signature days relative to
+	// today (UTC)
+	SignatureDayOffsets []int
+	// SigningKeys are named secrets clients select with key_id
+	SigningKeys map[string]string
+	// ClientKeys are per-client secrets selected with client_id and used
+	// with HMAC, so a single client can be revoked and its invalid
+	// attempts attributed
+	ClientKeys map[string]string
+	// UnkeyedSignatures accepts pings without key_id, signed with Secret
+	// or the built-in secret
+	UnkeyedSignatures bool
+	// Secret replaces the public built-in secret for pings without key_id,
+	// which are then signed with HMAC-SHA256(Secret, date)[:16]
+	Secret string
+	// NonceRequired rejects pings without a nonce, so no signed ping can
+	// be replayed
+	NonceRequired bool
+	// AccessWindows limits the key and client IDs listed to daily UTC
+	// time spans; pings outside them get outside_access_window
+	AccessWindows map[string][]AccessWindow
+	// ClockSkewThreshold is the clock difference reported to clients
+	ClockSkewThreshold time.Duration
+	// MaxMessageSize is the largest accepted WebSocket message in bytes
+	MaxMessageSize int64
+	// ReadBufferSize and WriteBufferSize are the per-connection WebSocket
+	// buffers in bytes
+	ReadBufferSize  int
+	WriteBufferSize int
+	// WSCompression negotiates permessage-deflate
+	WSCompression bool
+	// KeepAliveInterval keeps connections open for further pings after the
+	// first pong, sending control pings at this interval; peers silent for
+	// KeepAliveTimeout after a ping are disconnected. Zero closes the
+	// connection after the first pong.
+	KeepAliveInterval time.Duration
+	KeepAliveTimeout  time.Duration
+	// MaxConnections caps concurrent requests to the endpoints, of which
+	// anonymous traffic may hold AnonymousShare; registered clients and
+	// persistent sessions may use them all. Zero is unlimited.
+	MaxConnections int
+	AnonymousShare float64
+	// Probe accepts probe messages for frame-size probing, up to
+	// ProbeMaxSize bytes per frame
+	Probe        bool
+	ProbeMaxSize int
+	// StatsMaxIPs caps the client IPs tracked for connection statistics
+	StatsMaxIPs int
+	// MaxTags caps the distinct connection tags counted, later ones are
+	// counted as "(other)"
+	MaxTags int
+	// HeatmapHours is how long latencies are kept for the heatmap in
+	// /admin/heatmap, for up to HeatmapMaxClients client IPs
+	HeatmapHours      int
+	HeatmapMaxClients int
+
+	// Pings are answered with status "maintenance" when MaintenanceMode is
+	// set, while MaintenanceFile exists or during one of the windows
+	MaintenanceMode    bool
+	MaintenanceFile    string
+	MaintenanceWindows []MaintenanceWindow
+	// DegradedStatus answers pings with status "degraded" and the failing
+	// backends while the ClickHouse sink or the rate limiter reports a
+	// health problem
+	DegradedStatus bool
+
+	// WebSocket serves the ping endpoint at /ws
+	WebSocket bool
+	// EventsFeed serves starts, shutdowns, reloads, health changes and
+	// scheduled maintenance under /api/events as JSON, Atom and RSS;
+	// EventsFile keeps them across restarts
+	EventsFeed bool
+	EventsFile string
+	// HTTPPing answers pings POSTed to /ping, for clients without
+	// WebSockets
+	HTTPPing bool
+	// Landing serves the certificate acceptance page at / when TLS is
+	// enabled, from LandingTemplate if set
+	Landing         bool
+	LandingTemplate string
+	// LandingPush lists paths, such as "/client.js", pushed to HTTP/2
+	// clients along with the landing page
+	LandingPush []string
+	// Favicon serves a status-aware /favicon.ico
+	Favicon bool
+	// RobotsTxt serves a /robots.txt disallowing all crawling
+	RobotsTxt bool
+	// WellKnownDir and StaticDir are served under /.well-known/ and
+	// /static/ when set
+	WellKnownDir string
+	StaticDir    string
+	// AdminToken enables the admin API under /admin/ with the admin role;
+	// AdminTokens adds tokens with other roles
+	AdminToken  string
+	AdminTokens map[string]AdminRole
+	// Metrics serves Prometheus metrics at /metrics, only to requests
+	// bearing MetricsToken when set
+	Metrics      bool
+	MetricsToken string
+	// HealthChecks serves /healthz and /readyz, only to connections from
+	// HealthAllow when set
+	HealthChecks bool
+	HealthAllow  []*net.IPNet
+	// AllowCIDRs restricts the ping endpoints (/ws, /ping,
+	// /api/conformance, ALPN and TCP pings) to connections from these
+	// networks when set; DenyCIDRs drops connections from its networks
+	AllowCIDRs []*net.IPNet
+	DenyCIDRs  []*net.IPNet
+	// TrustedProxies are the reverse proxies whose X-Real-IP and
+	// X-Forwarded-For headers rate limits and bans go by; from anywhere
+	// else the socket address counts, since the headers could be forged
+	TrustedProxies []*net.IPNet
+	// Whoami serves /api/whoami
+	Whoami bool
+	// ALPNPing serves the line-based ping protocol to TLS clients that
+	// negotiate ALPNPingProtocol
+	ALPNPing bool
+	// TCPPingAddr is an address, e.g. ":8444", where Serve also answers
+	// the line-based ping protocol over plain TCP, for devices without
+	// WebSocket or TLS stacks and for nc
+	TCPPingAddr string
+	// TCPPingListener answers TCP pings instead of a listener on
+	// TCPPingAddr, e.g. a socket inherited in a graceful restart; Serve
+	// closes it on shutdown
+	TCPPingListener net.Listener
+	// Conformance serves the client conformance suite at
+	// /api/conformance
+	Conformance bool
+	// TimeService serves the server clock at /api/time and answers "time"
+	// messages on /ws
+	TimeService bool
+	// APISpec serves the OpenAPI and AsyncAPI specs under /api/spec
+	APISpec bool
+	// ClientJS serves the browser client at /client.js
+	ClientJS bool
+	// Compression enables gzip/deflate for HTML, static files and
+	// /client.js
+	Compression bool
+	// MethodNotAllowed answers wrong methods on enabled endpoints with 405
+	// and an Allow header instead of dropping the connection
+	MethodNotAllowed bool
+
+	// SelfCheck checks on startup that the port is reachable on the public
+	// addresses reported by the echo services
+	SelfCheck        bool
+	SelfCheckIPv4URL string
+	SelfCheckIPv6URL string
+
+	// Sinks receive a sample of every ping exchange, in addition to the
+	// built-in sinks below
+	Sinks []SampleSink
+	// EncryptionKey enables NaCl box sealed messages (SealedMessage) with
+	// this private key; EncryptionClientKeys restricts the client public
+	// keys, by name, and EncryptionRequired rejects plaintext messages
+	EncryptionKey        *[32]byte
+	EncryptionClientKeys map[string][32]byte
+	EncryptionRequired   bool
+
+	// ClickHouseURL enables the ClickHouse analytics sink
+	ClickHouseURL           string
+	ClickHouseTable         string
+	ClickHouseBatchSize     int
+	ClickHouseFlushInterval time.Duration
+	// ClickHouseProxy overrides OutboundProxy for the ClickHouse sink
+	ClickHouseProxy *url.URL
+	// AuthAnomalyDetection alerts when invalid signatures spike above
+	// AuthAnomalyFactor times their baseline and AuthAnomalyMin per interval
+	AuthAnomalyDetection bool
+	AuthAnomalyInterval  time.Duration
+	AuthAnomalyFactor    float64
+	AuthAnomalyMin       uint64
+
+	// SLOs are tracked with burn-rate alerts in the log and health, and
+	// listed by the admin API and metrics
+	SLOs []SLO
+	// MirrorURL receives a copy of MirrorRate of the incoming pings
+	MirrorURL      string
+	MirrorRate     float64
+	MirrorInsecure bool
+	// MirrorProxy overrides OutboundProxy for the ping mirror
+	MirrorProxy *url.URL
+	// OutboundProxy routes the ClickHouse sink and the ping mirror through
+	// an HTTP, HTTPS or SOCKS5 proxy; when nil they follow HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY
+	OutboundProxy *url.URL
+	// AuthHook is a command that can reject correctly signed pings
+	AuthHook        string
+	AuthHookTimeout time.Duration
+	// AuthHookConcurrency caps the AuthHook commands running at once;
+	// pings beyond it are denied
+	AuthHookConcurrency int
+	// AuthHookCacheTTL is how long a decision of AuthHook is reused for
+	// pings from the same client IP and key
+	AuthHookCacheTTL time.Duration
+	// EventHook is a long-running command receiving every sample on stdin
+	EventHook string
+
+	// RateLimiter limits connections per client IP, e.g. a
+	// MemoryRateLimiter, a RedisRateLimiter or an existing limiter
+	// adapted to the interface
+	RateLimiter RateLimiter
+	// BanThreshold invalid signatures from a client IP within BanWindow
+	// get all its traffic dropped for BanDuration; zero disables bans
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+
+	// AuthFailureLog is a file that invalid signatures and message types
+	// are appended to in a fixed format, e.g. for fail2ban
+	AuthFailureLog string
+
+	// WatchdogTimeout is how long a request or ping exchange may run before
+	// the watchdog logs the goroutine stacks; zero disables the watchdog
+	WatchdogTimeout time.Duration
+	// CrashReportDSN is a Sentry DSN that panics are reported to
+	CrashReportDSN string
+
+	// Clock replaces the system clock, e.g. with a FakeClock in tests
+	Clock Clock
+	// Dialer replaces the net.Dialer of outbound connections, e.g. with a
+	// PipeListener standing in for a ClickHouse server or mirror
+	Dialer Dialer
+}
+
+// DefaultACMECacheDir keeps the account key and certificates obtained via
+// ACME across restarts, so they aren't requested again each time
+const DefaultACMECacheDir = "acme-cache"
+
+// DefaultConfig returns the configuration the ming-mong binary uses when no
+// environment variables are set, without TLS
+func DefaultConfig() Config {
+	return Config{
+		Addr:                    ":8443",
+		CertReloadInterval:      30 * time.Second,
+		ACMECacheDir:            DefaultACMECacheDir,
+		TCP:                     DefaultTCPOptions,
+		HandshakeTimeout:        10 * time.Second,
+		IdleTimeout:             60 * time.Second,
+		DrainTimeout:            30 * time.Second,
+		SignatureDayOffsets:     DefaultSignatureDayOffsets,
+		UnkeyedSignatures:       true,
+		ClockSkewThreshold:      5 * time.Second,
+		MaxMessageSize:          4096,
+		ReadBufferSize:          4096,
+		WriteBufferSize:         4096,
+		KeepAliveTimeout:        10 * time.Second,
+		ProbeMaxSize:            65536,
+		StatsMaxIPs:             10000,
+		MaxTags:                 100,
+		HeatmapHours:            7 * 24,
+		HeatmapMaxClients:       100,
+		WebSocket:               true,
+		Landing:                 true,
+		Compression:             true,
+		SelfCheckIPv4URL:        "https://api.ipify.org",
+		SelfCheckIPv6URL:        "https://api6.ipify.org",
+		ClickHouseTable:         "ming_mong_pings",
+		ClickHouseBatchSize:     1000,
+		ClickHouseFlushInterval: 5 * time.Second,
+		AuthAnomalyInterval:     time.Minute,
+		AuthAnomalyFactor:       5,
+		AuthAnomalyMin:          30,
+		MirrorRate:              1,
+		AnonymousShare:          0.5,
+		AuthHookTimeout:         time.Second,
+		AuthHookConcurrency:     16,
+		AuthHookCacheTTL:        5 * time.Second,
+		BanWindow:               10 * time.Minute,
+		BanDuration:             15 * time.Minute,
+		WatchdogTimeout:         time.Minute,
+	}
+}
+
+// Server is a ming-mong ping/pong service
+type Server struct {
+	config   Config
+	clock    Clock
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+	started  time.Time
+
+	stats     *connectionStats
+	clients   *clientStats
+	tags      *tagStats
+	metrics   *pingMetrics
+	heatmap   *latencyHeatmap
+	nonces    *nonceCache
+	keyStats  *clientKeyStats
+	admission *admissionControl
+	bans      *banList
+	watchdog  *watchdog
+	crashes   *crashReporter
+	authLog   *authFailureLog
+	events    *eventLog
+	health    *healthRegistry
+	timings   *stageTimings
+	sinks     []SampleSink
+	authHook  *authHook
+	mirror    *pingMirror
+	sealer    *messageSealer
+	slos      []*sloTracker
+	tlsConfig *tls.Config
+	certs     *certReloader
+
+	// alpn are the handlers of protocols other than HTTP, see HandleALPN
+	alpn map[string]func(context.Context, *tls.Conn)
+
+	// maintenance and signatures are replaced by Reload
+	maintenance atomic.Pointer[maintenanceSchedule]
+	signatures  atomic.Pointer[signatureVerifier]
+	// maintenanceToggle is switched through the admin API and kept
+	// across reloads
+	maintenanceToggle atomic.Bool
+
+	// rateLimitFailing is set while the rate limiter returns errors
+	rateLimitFailing atomic.Bool
+
+	// drainCtx is cancelled when a shutdown starts, connCtx when it gives
+	// up waiting for in-flight connections; workerCtx stops the background
+	// workers afterwards
+	drainCtx          context.Context
+	startDrain        context.CancelFunc
+	connCtx           context.Context
+	cancelConnections context.CancelFunc
+	workerCtx         context.Context
+	stopWorkers       context.CancelFunc
+	activeConns       sync.WaitGroup
+	workers           sync.WaitGroup
+	closeOnce         sync.Once
+}
+
+// New validates config and sets up the server and its background workers.
+// Call Close when the server is not run with Run or Serve.
+func New(config Config) (*Server, error) {
+	if !config.UnkeyedSignatures && len(config.SigningKeys) == 0 && len(config.ClientKeys) == 0 {
+		return nil, errors.New("unkeyed signatures disabled without signing or client keys")
+	}
+	if config.AnonymousShare < 0 || config.AnonymousShare > 1 {
+		return nil, fmt.Errorf("anonymous share %g outside 0..1", config.AnonymousShare)
+	}
+	for _, path := range config.LandingPush {
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid landing push path: %q", path)
+		}
+	}
+	if config.MaxMessageSize <= 0 {
+		return nil, fmt.Errorf("invalid max message size: %d", config.MaxMessageSize)
+	}
+	if config.AuthHook != "" && config.AuthHookConcurrency <= 0 {
+		return nil, fmt.Errorf("invalid auth hook concurrency: %d", config.AuthHookConcurrency)
+	}
+
+	s := &Server{
+		config: config,
+		mux:    http.NewServeMux(),
+		upgrader: websocket.Upgrader{
+			// Slow clients may not hold sockets while the upgrade
+			// response is written
+			HandshakeTimeout:  config.HandshakeTimeout,
+			ReadBufferSize:    config.ReadBufferSize,
+			WriteBufferSize:   config.WriteBufferSize,
+			EnableCompression: config.WSCompression,
+			CheckOrigin: func(r *http.Request) bool {
+				// Allow all origins for CORS
+				return true
+			},
+		},
+		clients: newClientStats(),
+		tags:    newTagStats(config.MaxTags),
+		metrics: newPingMetrics(),
+		heatmap: newLatencyHeatmap(config.HeatmapHours, config.HeatmapMaxClients),
+		nonces:  newNonceCache(),
+		health:  newHealthRegistry(),
+		timings: newStageTimings(),
+		sinks:   append([]SampleSink(nil), config.Sinks...),
+	}
+	s.clock = config.Clock
+	if s.clock == nil {
+		s.clock = systemClock{}
+	}
+	s.started = s.clock.Now()
+	s.stats = newConnectionStats(config.StatsMaxIPs, s.clock)
+	if limiter, ok := config.RateLimiter.(*MemoryRateLimiter); ok && config.Clock != nil {
+		limiter.setClock(config.Clock)
+	}
+	s.keyStats = newClientKeyStats()
+	s.admission = newAdmissionControl(config.MaxConnections, config.AnonymousShare)
+	s.bans = newBanList(config.BanThreshold, config.BanWindow, config.BanDuration, config.StatsMaxIPs)
+	s.maintenance.Store(newMaintenanceSchedule(config))
+	s.signatures.Store(newSignatureVerifier(config))
+	s.drainCtx, s.startDrain = context.WithCancel(context.Background())
+	s.connCtx, s.cancelConnections = context.WithCancel(context.Background())
+	s.workerCtx, s.stopWorkers = context.WithCancel(context.Background())
+
+	if err := s.setup(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setup creates the configured components and registers the routes
+func (s *Server) setup() error {
+	config := s.config
+
+	// Certificates are re-read when the files change (certbot renewals,
+	// Kubernetes secret updates) without restarting
+	if config.CertFile != "" && config.KeyFile != "" {
+		reloader, err := newCertReloader(config.CertFile, config.KeyFile, s.health)
+		if err != nil {
+			return fmt.Errorf("load TLS certificate: %w", err)
+		}
+		s.startWorker(func(ctx context.Context) {
+			reloader.watch(ctx, config.CertReloadInterval)
+		})
+		s.certs = reloader
+
+		// Handshakes use per-connection copies of this config, which
+		// don't see the HTTP/2 protocol ServeTLS adds to its own copy
+		s.tlsConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			NextProtos:     []string{"h2", "http/1.1"},
+		}
+		s.timings.timeHandshakes(s.tlsConfig)
+	}
+
+	if len(config.ACMEDomains) > 0 {
+		if s.tlsConfig != nil {
+			return fmt.Errorf("ACME domains and certificate files are mutually exclusive")
+		}
+		tlsConfig, err := s.newACMEConfig(config)
+		if err != nil {
+			return err
+		}
+		s.tlsConfig = tlsConfig
+		s.timings.timeHandshakes(s.tlsConfig)
+		slog.Info("ACME certificates enabled", "domains", strings.Join(config.ACMEDomains, ","), "cache", config.ACMECacheDir)
+	}
+
+	// Application-layer encryption for untrusted TLS terminators
+	if config.EncryptionKey != nil {
+		sealer, err := newMessageSealer(config)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+		s.sealer = sealer
+		slog.Info("Message encryption enabled", "public_key", s.EncryptionPublicKey(),
+			"client_keys", len(config.EncryptionClientKeys), "required", config.EncryptionRequired)
+	} else if config.EncryptionRequired {
+		return fmt.Errorf("encryption required without an encryption key")
+	}
+
+	// Per-hour latencies for the admin heatmap
+	s.sinks = append(s.sinks, s.heatmap)
+
+	// Optional ClickHouse analytics sink
+	if config.ClickHouseURL != "" {
+		sink, err := newClickHouseSink(config.ClickHouseURL, config.ClickHouseTable,
+			config.ClickHouseBatchSize, config.ClickHouseFlushInterval,
+			proxyFunc(config.ClickHouseProxy, config.OutboundProxy), config.Dialer, s.health)
+		if err != nil {
+			return fmt.Errorf("ClickHouse sink: %w", err)
+		}
+		s.startWorker(sink.run)
+		s.sinks = append(s.sinks, sink)
+		slog.Info("ClickHouse analytics enabled", "table", config.ClickHouseTable,
+			"batch", config.ClickHouseBatchSize, "flush", config.ClickHouseFlushInterval)
+	}
+
+	// Alert on sudden spikes of invalid signatures
+	if config.AuthAnomalyDetection {
+		detector := newAuthAnomalyDetector(s.health, config.AuthAnomalyInterval,
+			config.AuthAnomalyFactor, config.AuthAnomalyMin, s.inMaintenance)
+		s.startWorker(detector.run)
+		s.sinks = append(s.sinks, detector)
+	}
+
+	// Error budget burn rates of the configured SLOs
+	for _, slo := range config.SLOs {
+		tracker := newSLOTracker(slo, s.clock, s.health, s.inMaintenance)
+		s.startWorker(tracker.run)
+		s.sinks = append(s.sinks, tracker)
+		s.slos = append(s.slos, tracker)
+	}
+
+	// Shadow traffic to a secondary instance
+	if config.MirrorURL != "" {
+		s.mirror = newPingMirror(config.MirrorURL, config.MirrorRate, config.MirrorInsecure,
+			proxyFunc(config.MirrorProxy, config.OutboundProxy), config.Dialer)
+		s.startWorker(s.mirror.run)
+		slog.Info("Mirroring pings", "rate", config.MirrorRate, "url", config.MirrorURL)
+	}
+
+	// Operational history, fed by the health registry among others
+	events, err := newEventLog(config.EventsFile, s.clock)
+	if err != nil {
+		return err
+	}
+	s.events = events
+	s.health.onChange = s.events.healthEvents
+
+	if config.AuthFailureLog != "" {
+		authLog, err := newAuthFailureLog(config.AuthFailureLog)
+		if err != nil {
+			return err
+		}
+		s.authLog = authLog
+	}
+
+	// Stuck requests, stalls and panics
+	if config.CrashReportDSN != "" {
+		reporter, err := newCrashReporter(config.CrashReportDSN, proxyFunc(config.OutboundProxy), config.Dialer)
+		if err != nil {
+			return err
+		}
+		s.crashes = reporter
+	}
+	if s.watchdog = newWatchdog(config.WatchdogTimeout, s.health); s.watchdog != nil {
+		s.startWorker(s.watchdog.run)
+	}
+
+	// External commands for site-specific policies and event handling
+	if config.AuthHook != "" {
+		s.authHook = newAuthHook(config.AuthHook, config.AuthHookTimeout, config.AuthHookConcurrency, config.AuthHookCacheTTL, s.clock)
+	}
+	if config.EventHook != "" {
+		hook := newEventHook(config.EventHook)
+		s.startWorker(hook.run)
+		s.sinks = append(s.sinks, hook)
+	}
+
+	return s.registerRoutes()
+}
+
+// registerRoutes sets up the enabled endpoints; every other path gets its
+// connection dropped
+func (s *Server) registerRoutes() error {
+	config := s.config
+
+	// Landing page shown for certificate acceptance when TLS is enabled
+	var landing http.HandlerFunc
+	if s.tlsConfig != nil && config.Landing {
+		handler, err := newLandingHandler(config.LandingTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid landing page template: %w", err)
+		}
+		landing = handler
+	}
+
+	if config.WebSocket {
+		s.handle("/ws", s.withPeerFilter(http.HandlerFunc(s.handleWebSocket)))
+	} else {
+		slog.Info("WebSocket endpoint disabled - /ws is dropped like any unknown path")
+	}
+	if config.HTTPPing {
+		s.handle("/ping", s.withPeerFilter(s.allowMethods(http.HandlerFunc(s.handleHTTPPing), http.MethodPost, http.MethodOptions)))
+	}
+
+	// Status-aware favicon
+	if config.Favicon {
+		s.handle("/favicon.ico", s.allowMethods(newFaviconHandler(s.health), http.MethodGet, http.MethodHead))
+	}
+
+	// robots.txt keeps well-behaved crawlers away from every path
+	if config.RobotsTxt {
+		s.handle("/robots.txt", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("User-agent: *\nDisallow: /\n"))
+		}))
+	}
+
+	// Optional /.well-known/ files (ACME HTTP-01 challenges, security.txt)
+	if config.WellKnownDir != "" {
+		if info, err := os.Stat(config.WellKnownDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid well-known directory: %s", config.WellKnownDir)
+		}
+		s.handle("/.well-known/", unwatched(s.allowMethods(newStaticHandler("/.well-known/", config.WellKnownDir), http.MethodGet, http.MethodHead)))
+		slog.Info("Serving /.well-known/", "dir", config.WellKnownDir)
+	}
+
+	// Optional static files (JS client, dashboards, favicon)
+	if config.StaticDir != "" {
+		if info, err := os.Stat(config.StaticDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid static directory: %s", config.StaticDir)
+		}
+		s.handle("/static/", unwatched(s.allowMethods(s.withCompression(newStaticHandler("/static/", config.StaticDir)), http.MethodGet, http.MethodHead)))
+		slog.Info("Serving static files at /static/", "dir", config.StaticDir)
+	}
+
+	// Token-protected admin API, not counted in the client statistics
+	// since unauthorized requests are mostly scanners
+	tokens := make(map[string]AdminRole, len(config.AdminTokens)+1)
+	for token, role := range config.AdminTokens {
+		if _, ok := roleNames[role]; !ok || token == "" {
+			return fmt.Errorf("invalid admin token with role %s", role)
+		}
+		tokens[token] = role
+	}
+	if config.AdminToken != "" {
+		tokens[config.AdminToken] = RoleAdmin
+	}
+	if len(tokens) > 0 {
+		s.mux.Handle("/admin/", s.newAdminHandler(tokens))
+		slog.Info("Admin API enabled at /admin/")
+	}
+
+	// Prometheus metrics, not counted in the client statistics like the
+	// admin API
+	if config.Metrics {
+		s.mux.Handle("/metrics", s.newMetricsHandler(config.MetricsToken))
+	}
+
+	// Liveness and readiness for orchestrators, not counted in the client
+	// statistics since they are polled constantly
+	if config.HealthChecks {
+		s.mux.Handle("/healthz", s.newHealthzHandler(config.HealthAllow, false))
+		s.mux.Handle("/readyz", s.newHealthzHandler(config.HealthAllow, true))
+	}
+
+	// Caller's observed address for clients behind NAT
+	if config.Whoami {
+		s.handle("/api/whoami", s.allowMethods(http.HandlerFunc(handleWhoami), http.MethodGet))
+	}
+
+	// Operational history for status aggregators and feed readers
+	if config.EventsFeed {
+		events := unwatched(s.allowMethods(http.HandlerFunc(s.handleEvents), http.MethodGet))
+		for _, path := range []string{"/api/events", "/api/events.atom", "/api/events.rss"} {
+			s.handle(path, events)
+		}
+	}
+
+	// Coarse time source for devices that can't reach NTP
+	if config.TimeService {
+		s.handle("/api/time", s.allowMethods(http.HandlerFunc(handleTime), http.MethodGet))
+	}
+
+	// Line-based pings for clients without HTTP, on the TLS port
+	if config.ALPNPing {
+		if s.tlsConfig == nil {
+			return fmt.Errorf("ALPN ping protocol requires TLS")
+		}
+		s.HandleALPN(ALPNPingProtocol, s.handleALPNPing)
+	}
+
+	// Scripted edge cases for third-party client implementations
+	if config.Conformance {
+		s.handle("/api/conformance", s.withPeerFilter(http.HandlerFunc(s.handleConformance)))
+	}
+
+	// Machine-readable API specs for generating client SDKs
+	if config.APISpec {
+		s.handle("/api/spec", http.HandlerFunc(handleSpec))
+		s.handle("/api/spec/", http.HandlerFunc(handleSpec))
+	}
+
+	// Optional browser client library
+	if config.ClientJS {
+		s.handle("/client.js", unwatched(s.allowMethods(s.withCompression(loadAsset("client.js")), http.MethodGet, http.MethodHead)))
+	}
+
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && landing != nil {
+			s.clients.record("/", r.UserAgent(), r.Header.Get("Origin"))
+			s.pushAssets(w, r)
+			unwatched(s.withCompression(landing)).ServeHTTP(w, r)
+			return
+		}
+
+		// Stealth mode for all other paths
+		dropConnection(w)
+	})
+	return nil
+}
+
+// handle registers handler for pattern, counting the User-Agent and Origin
+// of its requests
+func (s *Server) handle(pattern string, handler http.Handler) {
+	handler = s.withAdmission(pattern, handler)
+	s.mux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.clients.record(pattern, r.UserAgent(), r.Header.Get("Origin"))
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// Handler returns the HTTP handler serving all enabled endpoints, for
+// mounting into an existing server. Unknown paths get their connection
+// dropped.
+func (s *Server) Handler() http.Handler {
+	return s.withBans(s.withWatchdog(s.mux))
+}
+
+// TLS reports whether the server terminates TLS itself
+func (s *Server) TLS() bool {
+	return s.tlsConfig != nil
+}
+
+// Run listens on the configured address and serves until ctx is cancelled
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := Listen(s.config.Addr, s.config.TCP, false)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve accepts connections on listeners until ctx is cancelled, then
+// drains in-flight connections for up to the drain timeout and closes the
+// server. It returns nil after a graceful stop, or the first listener
+// error.
+func (s *Server) Serve(ctx context.Context, listeners ...net.Listener) error {
+	defer s.Close()
+	if len(listeners) == 0 {
+		return errors.New("no listeners")
+	}
+
+	// The header timeout also bounds the TLS handshake, so slow clients
+	// may not hold sockets before the upgrade
+	httpServer := &http.Server{
+		Handler:           s.Handler(),
+		ConnContext:       withConnTiming,
+		ReadHeaderTimeout: s.config.HandshakeTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		TLSConfig:         s.tlsConfig,
+	}
+	httpServer.RegisterOnShutdown(s.startDrain)
+	if err := s.routeALPN(httpServer); err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(listeners)+1)
+	tcpPing := s.config.TCPPingListener
+	if tcpPing == nil && s.config.TCPPingAddr != "" {
+		// SO_REUSEPORT lets the next process of a restart that doesn't
+		// hand over TCPPingListener bind while this one drains
+		ln, err := Listen(s.config.TCPPingAddr, s.config.TCP, ReusePortSupported)
+		if err != nil {
+			return fmt.Errorf("listen for TCP pings: %w", err)
+		}
+		tcpPing = ln
+	}
+	if tcpPing != nil {
+		slog.Info("Answering TCP pings", "addr", tcpPing.Addr().String())
+		go func() {
+			if err := s.ServeTCPPing(tcpPing); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if s.tlsConfig != nil {
+				errs <- httpServer.ServeTLS(ln, "", "")
+			} else {
+				errs <- httpServer.Serve(ln)
+			}
+		}(ln)
+	}
+
+	s.events.record("start", "Server started", "version "+Version)
+
+	// Optional public address and reachability self-report
+	if s.config.SelfCheck {
+		if _, port, err := net.SplitHostPort(listeners[0].Addr().String()); err == nil {
+			go selfCheck(port, s.config.SelfCheckIPv4URL, s.config.SelfCheckIPv6URL, s.health)
+		}
+	}
+
+	select {
+	case err := <-errs:
+		httpServer.Close()
+		return err
+	case <-ctx.Done():
+		s.events.record("shutdown", "Server shutting down", "")
+		s.drain(httpServer, s.config.DrainTimeout)
+		return nil
+	}
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSignatureDayOffsets accepts today and yesterday (UTC), so a client
+// whose clock has already passed UTC midnight is still valid while one
+// that has not yet caught up is too
+var DefaultSignatureDayOffsets = []int{0, -1}
+
+// defaultSecret signs pings that carry no key ID unless Config.Secret
+// replaces it. It is public, so anyone can sign with it.
+const defaultSecret = "ming-mong-server"
+
+// signingKey is a secret and the scheme signatures with it are made with
+type signingKey struct {
+	secret string
+	// hmac selects HMAC-SHA256 keyed by secret instead of the legacy
+	// SHA256(date + secret)
+	hmac bool
+}
+
+func (k signingKey) sign(date string) string {
+	if k.hmac {
+		return generateHMACSignature(date, k.secret)
+	}
+	return generateSignature(date, k.secret)
+}
+
+// signatureVerifier checks ping signatures against the accepted days and
+// secrets
+type signatureVerifier struct {
+	// dayOffsets lists the UTC days, relative to today, whose signatures
+	// are accepted
+	dayOffsets []int
+	// keys are the named secrets clients may select with key_id, so client
+	// populations can move between secrets one at a time
+	keys map[string]string
+	// clients are the per-client secrets selected with client_id, signed
+	// with HMAC
+	clients map[string]string
+	// unkeyed allows pings without key_id, signed with secret using HMAC
+	// or with defaultSecret when secret is empty
+	unkeyed bool
+	secret  string
+	// windows restrict key and client IDs to times of day
+	windows map[string][]AccessWindow
+}
+
+func newSignatureVerifier(config Config) *signatureVerifier {
+	return &signatureVerifier{
+		dayOffsets: config.SignatureDayOffsets,
+		keys:       config.SigningKeys,
+		clients:    config.ClientKeys,
+		unkeyed:    config.UnkeyedSignatures,
+		secret:     config.Secret,
+		windows:    config.AccessWindows,
+	}
+}
+
+func generateSignature(date, secret string) string {
+	data := date + secret
+	hash := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
+// generateHMACSignature signs date with HMAC-SHA256, which unlike
+// generateSignature can't be forged without the secret
+func generateHMACSignature(date, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(date))
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// unkeyedKey is the key pings without key_id are signed with
+func (v *signatureVerifier) unkeyedKey() signingKey {
+	if v.secret != "" {
+		return signingKey{secret: v.secret, hmac: true}
+	}
+	return signingKey{secret: defaultSecret}
+}
+
+// keyFor returns the key a ping with keyID or clientID, at most one of
+// them, must be signed with
+func (v *signatureVerifier) keyFor(keyID, clientID string) (signingKey, bool) {
+	switch {
+	case keyID != "" && clientID != "":
+		return signingKey{}, false
+	case clientID != "":
+		secret, ok := v.clients[clientID]
+		return signingKey{secret: secret, hmac: true}, ok
+	case keyID != "":
+		secret, ok := v.keys[keyID]
+		return signingKey{secret: secret}, ok
+	}
+	return v.unkeyedKey(), v.unkeyed
+}
+
+// valid checks signature against the accepted days. A ping carrying a
+// nonce signs the date followed by the nonce, so the nonce can't be swapped
+// for a fresh one.
+func (v *signatureVerifier) valid(keyID, clientID, nonce, signature string, now time.Time) bool {
+	key, ok := v.keyFor(keyID, clientID)
+	if !ok {
+		return false
+	}
+
+	now = now.UTC()
+	for _, offset := range v.dayOffsets {
+		date := now.AddDate(0, 0, offset).Format("2006-01-02")
+		if signaturesEqual(signature, key.sign(date+nonce)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signaturesEqual compares in constant time, so response timing doesn't
+// reveal how much of a guessed signature was right
+func signaturesEqual(got, want string) bool {
+	return hmac.Equal([]byte(got), []byte(want))
+}
+
+// window is the longest time a signature stays valid: from the start of
+// the earliest accepted day until the end of the latest one
+func (v *signatureVerifier) window() time.Duration {
+	earliest, latest := slices.Min(v.dayOffsets), slices.Max(v.dayOffsets)
+	return time.Duration(latest-earliest+1) * 24 * time.Hour
+}
+
+// ParseSigningKeys reads a comma-separated list of key_id:secret pairs
+func ParseSigningKeys(value string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		keyID, secret, ok := strings.Cut(part, ":")
+		if !ok || keyID == "" || secret == "" {
+			return nil, fmt.Errorf("invalid key %q, expected key_id:secret", part)
+		}
+		if _, exists := keys[keyID]; exists {
+			return nil, fmt.Errorf("duplicate key ID %q", keyID)
+		}
+		keys[keyID] = secret
+	}
+	return keys, nil
+}
+
+// signatureMatch is the day and secret a supplied signature was generated
+// with
+type signatureMatch struct {
+	Date      string `json:"date"`
+	DayOffset int    `json:"day_offset"`
+	Accepted  bool   `json:"accepted"`
+	// Secret is the key ID of the secret, "client:<client_id>" for client
+	// keys, "default" for unkeyed pings and "builtin" for the public secret
+	// once a server secret replaces it
+	Secret string `json:"secret"`
+}
+
+// signatureReport explains why a signature is accepted or rejected
+type signatureReport struct {
+	KeyID     string          `json:"key_id,omitempty"`
+	ClientID  string          `json:"client_id,omitempty"`
+	Nonce     string          `json:"nonce,omitempty"`
+	Signature string          `json:"signature"`
+	Valid     bool            `json:"valid"`
+	Match     *signatureMatch `json:"match,omitempty"`
+	// Expected lists the accepted signature of each accepted day
+	Expected map[string]string `json:"expected"`
+	Hint     string            `json:"hint"`
+}
+
+// explainSignature looks for the day, within a week either way, and the
+// secret whose signature matches the supplied one
+func (v *signatureVerifier) explain(keyID, clientID, nonce, signature string, now time.Time) signatureReport {
+	now = now.UTC()
+	report := signatureReport{
+		KeyID:     keyID,
+		ClientID:  clientID,
+		Nonce:     nonce,
+		Signature: signature,
+		Valid:     v.valid(keyID, clientID, nonce, signature, now),
+		Expected:  make(map[string]string),
+	}
+	expectedKey, known := v.keyFor(keyID, clientID)
+	if known {
+		for _, offset := range v.dayOffsets {
+			date := now.AddDate(0, 0, offset).Format("2006-01-02")
+			report.Expected[date] = expectedKey.sign(date + nonce)
+		}
+	}
+
+	keys := map[string]signingKey{"default": v.unkeyedKey()}
+	if v.secret != "" {
+		// Clients not yet moved off the public secret
+		keys["builtin"] = signingKey{secret: defaultSecret}
+	}
+	for id, secret := range v.keys {
+		keys[id] = signingKey{secret: secret}
+	}
+	// Only the named client's secret is tried, not every client's
+	expected := "default"
+	switch {
+	case keyID != "":
+		expected = keyID
+	case clientID != "":
+		expected = "client:" + clientID
+		if known {
+			keys[expected] = expectedKey
+		}
+	}
+
+search:
+	for offset := -7; offset <= 7; offset++ {
+		date := now.AddDate(0, 0, offset).Format("2006-01-02")
+		for id, key := range keys {
+			if !signaturesEqual(signature, key.sign(date+nonce)) {
+				continue
+			}
+
+			accepted := known && key == expectedKey
+			if accepted {
+				accepted = false
+				for _, allowed := range v.dayOffsets {
+					accepted = accepted || allowed == offset
+				}
+			}
+			report.Match = &signatureMatch{Date: date, DayOffset: offset, Accepted: accepted, Secret: id}
+			break search
+		}
+	}
+
+	switch {
+	case keyID != "" && clientID != "":
+		report.Hint = "key_id and client_id are mutually exclusive"
+	case !known && clientID != "":
+		report.Hint = fmt.Sprintf("unknown or revoked client ID %q", clientID)
+	case !known && keyID != "":
+		report.Hint = fmt.Sprintf("unknown key ID %q", keyID)
+	case !known:
+		report.Hint = "pings without key_id are disabled"
+	case report.Match != nil && report.Match.Secret == "builtin":
+		report.Hint = "signed with the public built-in secret instead of HMAC with the server secret"
+	case report.Match == nil:
+		report.Hint = "no match within 7 days: wrong algorithm or secret, or a malformed date string"
+	case report.Match.Secret != expected:
+		report.Hint = fmt.Sprintf("signed with secret %q, not %q", report.Match.Secret, expected)
+	case report.Match.Accepted:
+		report.Hint = "signature is accepted"
+	case report.Match.DayOffset > 0:
+		report.Hint = fmt.Sprintf("signature is for %d day(s) ahead: client clock or timezone is ahead of UTC", report.Match.DayOffset)
+	default:
+		report.Hint = fmt.Sprintf("signature is %d day(s) old: client clock is behind or it caches signatures", -report.Match.DayOffset)
+	}
+	return report
+}
+
+// clockSkew compares the client-supplied timestamp with the server time and
+// reports the difference when it exceeds threshold. Network latency is
+// included, so the threshold should be well above typical RTTs.
+func clockSkew(timestamp string, now time.Time, threshold time.Duration) (time.Duration, bool) {
+	clientTime, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return 0, false
+	}
+
+	skew := clientTime.Sub(now)
+	if skew < threshold && skew > -threshold {
+		return 0, false
+	}
+	return skew, true
+}
+
+// ParseDayOffsets accepts a comma-separated list of offsets and ranges,
+// e.g. "-1..1" or "-1,0,1"
+func ParseDayOffsets(value string) ([]int, error) {
+	seen := make(map[int]bool)
+	var offsets []int
+
+	add := func(offset int) error {
+		if offset < -7 || offset > 7 {
+			return fmt.Errorf("offset %d out of range -7..7", offset)
+		}
+		if !seen[offset] {
+			seen[offset] = true
+			offsets = append(offsets, offset)
+		}
+		return nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if from, to, isRange := strings.Cut(part, ".."); isRange {
+			start, err := strconv.Atoi(strings.TrimSpace(from))
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			end, err := strconv.Atoi(strings.TrimSpace(to))
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+			for offset := start; offset <= end; offset++ {
+				if err := add(offset); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		offset, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %q", part)
+		}
+		if err := add(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("no offsets given")
+	}
+	return offsets, nil
+}
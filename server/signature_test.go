@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	verifier := &signatureVerifier{
+		dayOffsets: DefaultSignatureDayOffsets,
+		keys:       map[string]string{"v1": "legacy"},
+		clients:    map[string]string{"c1": "client-secret"},
+		unkeyed:    true,
+		secret:     "s3cret",
+	}
+
+	tests := []struct {
+		name                        string
+		keyID, clientID, nonce, sig string
+		now                         time.Time
+		want                        bool
+	}{
+		{"unkeyed HMAC today", "", "", "", "56de821c4db9c4b4", now, true},
+		{"unkeyed HMAC yesterday", "", "", "", "348b68299ab73cfa", now, true},
+		{"unkeyed HMAC two days old", "", "", "", "56de821c4db9c4b4", now.Add(48 * time.Hour), false},
+		{"built-in secret once a secret is set", "", "", "", "23b6e6df4ad3a967", now, false},
+		{"nonce signed with the date", "", "", "abc", "b21399ce2f8b5d28", now, true},
+		{"nonce swapped for another", "", "", "abd", "b21399ce2f8b5d28", now, false},
+		{"nonce dropped", "", "", "", "b21399ce2f8b5d28", now, false},
+		{"legacy signing key", "v1", "", "", "9f848d59f5a7e621", now, true},
+		{"unknown key ID", "v2", "", "", "9f848d59f5a7e621", now, false},
+		{"client key", "", "c1", "", "0db353456dc7fdc3", now, true},
+		{"client key under another client ID", "", "c2", "", "0db353456dc7fdc3", now, false},
+		{"key and client ID together", "v1", "c1", "", "9f848d59f5a7e621", now, false},
+		{"truncated signature", "", "", "", "56de821c4db9c4b", now, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := verifier.valid(test.keyID, test.clientID, test.nonce, test.sig, test.now); got != test.want {
+				t.Fatalf("valid = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifierBuiltinSecret(t *testing.T) {
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	verifier := &signatureVerifier{dayOffsets: DefaultSignatureDayOffsets, unkeyed: true}
+	if !verifier.valid("", "", "", "23b6e6df4ad3a967", now) {
+		t.Fatal("signature with the built-in secret rejected")
+	}
+
+	verifier.unkeyed = false
+	if verifier.valid("", "", "", "23b6e6df4ad3a967", now) {
+		t.Fatal("unkeyed signature accepted with unkeyed signatures off")
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SLO is a service level objective over the ping samples: at least
+// Objective of the pings in Window must be answered, within Latency when
+// it is set. Only answered pings and internal errors count as events;
+// errors caused by the client, such as invalid signatures, don't.
+type SLO struct {
+	Name string
+	// IP and Tag restrict the SLO to a client address or connection tag
+	IP        string
+	Tag       string
+	Latency   time.Duration
+	Objective float64
+	Window    time.Duration
+}
+
+// Burn rate thresholds of the multiwindow alerts: fast burn spends 2% of
+// the budget in Window/720 (1h of 30 days), slow burn 5% in Window/120
+// (6h); each is confirmed by a window a twelfth as long, so alerts clear
+// soon after the problem does
+const (
+	fastBurnRate = 14.4
+	slowBurnRate = 6
+)
+
+// ParseSLOs reads semicolon-separated SLOs of the form
+// name:key=value,..., with the keys objective (99.9% or 0.999), window
+// (30d, 12h), latency (200ms), ip and tag
+func ParseSLOs(value string) ([]SLO, error) {
+	var slos []SLO
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, fields, _ := strings.Cut(part, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("SLO %q without name", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate SLO %q", name)
+		}
+		seen[name] = true
+
+		slo := SLO{Name: name, Objective: 0.99, Window: 30 * 24 * time.Hour}
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("SLO %s: invalid field %q, expected key=value", name, field)
+			}
+			var err error
+			switch strings.TrimSpace(key) {
+			case "objective":
+				slo.Objective, err = parseObjective(strings.TrimSpace(val))
+			case "window":
+				slo.Window, err = parseWindow(strings.TrimSpace(val))
+			case "latency":
+				slo.Latency, err = time.ParseDuration(strings.TrimSpace(val))
+				if err == nil && slo.Latency <= 0 {
+					err = fmt.Errorf("latency must be positive")
+				}
+			case "ip":
+				slo.IP = strings.TrimSpace(val)
+			case "tag":
+				slo.Tag = strings.TrimSpace(val)
+			default:
+				err = fmt.Errorf("unknown field %q", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("SLO %s: %w", name, err)
+			}
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+// parseObjective reads "99.9%" or "0.999"
+func parseObjective(value string) (float64, error) {
+	percent := strings.HasSuffix(value, "%")
+	objective, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid objective %q", value)
+	}
+	if percent {
+		objective /= 100
+	}
+	if objective <= 0 || objective >= 1 {
+		return 0, fmt.Errorf("objective %q must be between 0 and 100%%", value)
+	}
+	return objective, nil
+}
+
+// parseWindow reads a duration that may be given in days, e.g. "30d"
+func parseWindow(value string) (time.Duration, error) {
+	var window time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+	}
+	if window < time.Hour {
+		return 0, fmt.Errorf("window %q shorter than 1h", value)
+	}
+	return window, nil
+}
+
+// sloEvents counts the events of a time bucket
+type sloEvents struct {
+	bucket int64
+	good   uint64
+	total  uint64
+}
+
+// eventRing keeps event counts in buckets of size, enough to cover span
+type eventRing struct {
+	size    time.Duration
+	buckets []sloEvents
+}
+
+func newEventRing(size, span time.Duration) *eventRing {
+	n := int((span + size - 1) / size)
+	return &eventRing{size: size, buckets: make([]sloEvents, n)}
+}
+
+func (r *eventRing) add(t time.Time, good bool) {
+	bucket := t.UnixNano() / int64(r.size)
+	entry := &r.buckets[bucket%int64(len(r.buckets))]
+	if entry.bucket != bucket {
+		*entry = sloEvents{bucket: bucket}
+	}
+	entry.total++
+	if good {
+		entry.good++
+	}
+}
+
+// sum counts the events of the buckets overlapping the span up to now
+func (r *eventRing) sum(now time.Time, span time.Duration) (good, total uint64) {
+	last := now.UnixNano() / int64(r.size)
+	first := last - int64((span+r.size-1)/r.size) + 1
+	for _, entry := range r.buckets {
+		if entry.bucket >= first && entry.bucket <= last {
+			good += entry.good
+			total += entry.total
+		}
+	}
+	return good, total
+}
+
+// sloTracker evaluates the burn rate of an SLO each minute, alerting in
+// the log and health when its error budget is spent too fast
+type sloTracker struct {
+	slo    SLO
+	clock  Clock
+	health *healthRegistry
+	// inMaintenance holds off alerts while failures are expected
+	inMaintenance func() bool
+
+	mu sync.Mutex
+	// minutes cover the longest alert window, hours the whole SLO window
+	minutes *eventRing
+	hours   *eventRing
+	alert   string
+}
+
+func newSLOTracker(slo SLO, clock Clock, health *healthRegistry, inMaintenance func() bool) *sloTracker {
+	return &sloTracker{
+		slo:           slo,
+		clock:         clock,
+		health:        health,
+		inMaintenance: inMaintenance,
+		minutes:       newEventRing(time.Minute, slo.Window/120),
+		hours:         newEventRing(time.Hour, slo.Window),
+	}
+}
+
+func (t *sloTracker) Record(sample PingSample) {
+	if (t.slo.IP != "" && sample.IP != t.slo.IP) || (t.slo.Tag != "" && sample.Tag != t.slo.Tag) {
+		return
+	}
+	var good bool
+	switch sample.Result {
+	case "ok", "time":
+		good = t.slo.Latency == 0 || sample.LatencyMs <= float64(t.slo.Latency.Microseconds())/1000
+	case "internal_error":
+	default:
+		return
+	}
+
+	t.mu.Lock()
+	t.minutes.add(sample.Timestamp, good)
+	t.hours.add(sample.Timestamp, good)
+	t.mu.Unlock()
+}
+
+// alertWindows are the long and short windows of the fast and slow burn
+// alerts, never shorter than the one-minute buckets
+func (t *sloTracker) alertWindows() (fastLong, fastShort, slowLong, slowShort time.Duration) {
+	atLeastMinute := func(d time.Duration) time.Duration {
+		return max(d.Round(time.Minute), time.Minute)
+	}
+	fastLong = atLeastMinute(t.slo.Window / 720)
+	slowLong = atLeastMinute(t.slo.Window / 120)
+	return fastLong, atLeastMinute(fastLong / 12), slowLong, atLeastMinute(slowLong / 12)
+}
+
+// burnRate is how many times faster than sustainable the budget was spent
+// over span; 1 spends it exactly by the end of the window
+func (t *sloTracker) burnRate(now time.Time, span time.Duration) float64 {
+	good, total := t.minutes.sum(now, span)
+	if total == 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - t.slo.Objective)
+}
+
+func (t *sloTracker) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.evaluate(t.clock.Now())
+		}
+	}
+}
+
+func (t *sloTracker) evaluate(now time.Time) {
+	if t.inMaintenance() {
+		return
+	}
+	fastLong, fastShort, slowLong, slowShort := t.alertWindows()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fast := t.burnRate(now, fastLong) > fastBurnRate && t.burnRate(now, fastShort) > fastBurnRate
+	slow := t.burnRate(now, slowLong) > slowBurnRate && t.burnRate(now, slowShort) > slowBurnRate
+
+	alert := ""
+	switch {
+	case fast:
+		alert = "fast_burn"
+	case slow:
+		alert = "slow_burn"
+	}
+	if alert == t.alert {
+		return
+	}
+
+	component := "slo_" + t.slo.Name
+	if alert != "" {
+		slog.Warn("SLO error budget burning", "slo", t.slo.Name, "alert", alert,
+			"burn_rate", t.burnRate(now, fastLong), "window", fastLong.String(),
+			"burn_rate_slow", t.burnRate(now, slowLong), "window_slow", slowLong.String())
+		t.health.set(component, HealthDegraded, fmt.Sprintf("%s: error budget burning too fast", alert))
+	} else {
+		slog.Info("SLO burn rate back to normal", "slo", t.slo.Name)
+		t.health.clear(component)
+	}
+	t.alert = alert
+}
+
+// sloStatus is the JSON form of an SLO and its current state
+type sloStatus struct {
+	Name      string  `json:"name"`
+	IP        string  `json:"ip,omitempty"`
+	Tag       string  `json:"tag,omitempty"`
+	Objective float64 `json:"objective"`
+	Window    string  `json:"window"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Good      uint64  `json:"good"`
+	Total     uint64  `json:"total"`
+	// BudgetRemaining is the share of the window's error budget left,
+	// negative once it is overspent
+	BudgetRemaining float64 `json:"budget_remaining"`
+	// BurnRates are by alert window, e.g. "1h0m0s"
+	BurnRates map[string]float64 `json:"burn_rates"`
+	Alert     string             `json:"alert,omitempty"`
+}
+
+func (t *sloTracker) status(now time.Time) sloStatus {
+	fastLong, fastShort, slowLong, slowShort := t.alertWindows()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := sloStatus{
+		Name:            t.slo.Name,
+		IP:              t.slo.IP,
+		Tag:             t.slo.Tag,
+		Objective:       t.slo.Objective,
+		Window:          t.slo.Window.String(),
+		LatencyMs:       float64(t.slo.Latency.Microseconds()) / 1000,
+		BudgetRemaining: 1,
+		BurnRates:       make(map[string]float64),
+		Alert:           t.alert,
+	}
+	status.Good, status.Total = t.hours.sum(now, t.slo.Window)
+	if status.Total > 0 {
+		budget := float64(status.Total) * (1 - t.slo.Objective)
+		status.BudgetRemaining = 1 - float64(status.Total-status.Good)/budget
+	}
+	for _, span := range []time.Duration{fastShort, slowShort, fastLong, slowLong} {
+		status.BurnRates[span.String()] = t.burnRate(now, span)
+	}
+	return status
+}
+
+// sloStatuses lists the state of every SLO, in configuration order
+func (s *Server) sloStatuses(now time.Time) []sloStatus {
+	statuses := make([]sloStatus, 0, len(s.slos))
+	for _, tracker := range s.slos {
+		statuses = append(statuses, tracker.status(now))
+	}
+	return statuses
+}
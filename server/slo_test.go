@@ -0,0 +1,65 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSLOs(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		name  string
+		value string
+		want  []SLO
+		err   string
+	}{
+		{name: "empty", value: " ; ", want: nil},
+		{
+			name:  "defaults",
+			value: "availability",
+			want:  []SLO{{Name: "availability", Objective: 0.99, Window: 30 * day}},
+		},
+		{
+			name:  "all fields",
+			value: "edge: objective=98%, window=7d, latency=200ms, ip=203.0.113.7, tag=eu",
+			want:  []SLO{{Name: "edge", IP: "203.0.113.7", Tag: "eu", Latency: 200 * time.Millisecond, Objective: 0.98, Window: 7 * day}},
+		},
+		{
+			name:  "several with a fractional objective and an hour window",
+			value: "a:objective=0.95;b:window=12h",
+			want: []SLO{
+				{Name: "a", Objective: 0.95, Window: 30 * day},
+				{Name: "b", Objective: 0.99, Window: 12 * time.Hour},
+			},
+		},
+		{name: "missing name", value: ":objective=99%", err: "without name"},
+		{name: "duplicate", value: "a;a", err: `duplicate SLO "a"`},
+		{name: "field without value", value: "a:objective", err: "expected key=value"},
+		{name: "unknown field", value: "a:budget=1", err: `unknown field "budget"`},
+		{name: "objective of 100%", value: "a:objective=100%", err: "must be between"},
+		{name: "objective of 0", value: "a:objective=0", err: "must be between"},
+		{name: "objective not a number", value: "a:objective=high", err: "invalid objective"},
+		{name: "window too short", value: "a:window=30m", err: "shorter than 1h"},
+		{name: "window in bad days", value: "a:window=xd", err: "invalid window"},
+		{name: "negative latency", value: "a:latency=-1s", err: "latency must be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseSLOs(test.value)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
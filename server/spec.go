@@ -0,0 +1,242 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The API specs are built from the message types by reflection, so they
+// can't drift from what the server actually sends and accepts
+
+// schemaOf returns the JSON schema of a Go value's JSON encoding
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// specSchemas are the named types referenced by both specs
+var specSchemas = map[string]reflect.Type{
+	"PingMessage":       reflect.TypeOf(PingMessage{}),
+	"PongMessage":       reflect.TypeOf(PongMessage{}),
+	"ProbeFrame":        reflect.TypeOf(ProbeFrame{}),
+	"ProbeAck":          reflect.TypeOf(ProbeAck{}),
+	"ProbeResult":       reflect.TypeOf(ProbeResult{}),
+	"TimeMessage":       reflect.TypeOf(TimeMessage{}),
+	"ConformanceCase":   reflect.TypeOf(ConformanceCase{}),
+	"ConformanceReply":  reflect.TypeOf(ConformanceReply{}),
+	"ConformanceReport": reflect.TypeOf(ConformanceReport{}),
+	"ObservedAddress":   reflect.TypeOf(ObservedAddress{}),
+	"ProxyInfo":         reflect.TypeOf(ProxyInfo{}),
+	"SealedMessage":     reflect.TypeOf(SealedMessage{}),
+	"IPStats":           reflect.TypeOf(ipStats{}),
+}
+
+func specComponents() map[string]interface{} {
+	schemas := map[string]interface{}{}
+	for name, t := range specSchemas {
+		schemas[name] = schemaOf(t)
+	}
+	return schemas
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// openAPISpec describes the HTTP endpoints
+func openAPISpec() map[string]interface{} {
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Ming-Mong HTTP API",
+			"version": Version,
+		},
+		"paths": map[string]interface{}{
+			"/ws": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "WebSocket ping endpoint, see the AsyncAPI spec for the message protocol",
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "tag", "in": "query", "description": "Freeform connection tag, up to 64 letters, digits, '.', '_' or '-'", "schema": map[string]interface{}{"type": "string"}},
+					},
+					"responses": map[string]interface{}{"101": map[string]interface{}{"description": "Switching Protocols"}},
+				},
+			},
+			"/api/whoami": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "The caller's address as seen by the server",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Observed address",
+							"content":     jsonContent(schemaRef("ObservedAddress")),
+						},
+					},
+				},
+			},
+			"/api/time": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "The server clock, for use as a coarse time source",
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "origin", "in": "query", "description": "Client send time, echoed back", "schema": map[string]interface{}{"type": "string"}},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Server time",
+							"content":     jsonContent(schemaRef("TimeMessage")),
+						},
+					},
+				},
+			},
+			"/admin/connections": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary":  "Top talkers by client IP",
+					"security": []interface{}{map[string]interface{}{"bearer": []interface{}{}}},
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "limit", "in": "query", "schema": map[string]interface{}{"type": "integer", "default": 20}},
+						map[string]interface{}{"name": "sort", "in": "query", "schema": map[string]interface{}{"type": "string", "enum": []string{"live", "total"}}},
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Connections",
+							"content": jsonContent(map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"connections": map[string]interface{}{"type": "array", "items": schemaRef("IPStats")},
+								},
+							}),
+						},
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": specComponents(),
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// asyncAPISpec describes the WebSocket message protocol
+func asyncAPISpec() map[string]interface{} {
+	message := func(name string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":    name,
+			"payload": schemaRef(name),
+		}
+	}
+
+	return map[string]interface{}{
+		"asyncapi": "2.6.0",
+		"info": map[string]interface{}{
+			"title":   "Ming-Mong WebSocket protocol",
+			"version": Version,
+		},
+		"defaultContentType": "application/json",
+		"channels": map[string]interface{}{
+			"/ws": map[string]interface{}{
+				"publish": map[string]interface{}{
+					"summary": "Messages sent by the client",
+					"message": map[string]interface{}{
+						"oneOf": []interface{}{message("PingMessage"), message("ProbeAck"), message("SealedMessage")},
+					},
+				},
+				"subscribe": map[string]interface{}{
+					"summary": "Messages sent by the server",
+					"message": map[string]interface{}{
+						"oneOf": []interface{}{message("PongMessage"), message("TimeMessage"), message("ProbeFrame"), message("ProbeResult"), message("SealedMessage")},
+					},
+				},
+			},
+			"/api/conformance": map[string]interface{}{
+				"publish": map[string]interface{}{
+					"summary": "A signed PingMessage of type conformance starts the run, then one reply per case",
+					"message": map[string]interface{}{
+						"oneOf": []interface{}{message("PingMessage"), message("ConformanceReply")},
+					},
+				},
+				"subscribe": map[string]interface{}{
+					"summary": "Each case is announced, followed by the scripted reply to test; a report ends the run",
+					"message": map[string]interface{}{
+						"oneOf": []interface{}{message("ConformanceCase"), message("ConformanceReport")},
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": specComponents(),
+		},
+	}
+}
+
+// handleSpec serves /api/spec/openapi.json and /api/spec/asyncapi.json,
+// with an index of both at /api/spec
+func handleSpec(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/spec", "/api/spec/":
+		writeJSON(w, http.StatusOK, map[string]string{
+			"openapi":  "/api/spec/openapi.json",
+			"asyncapi": "/api/spec/asyncapi.json",
+		})
+	case "/api/spec/openapi.json":
+		writeJSON(w, http.StatusOK, openAPISpec())
+	case "/api/spec/asyncapi.json":
+		writeJSON(w, http.StatusOK, asyncAPISpec())
+	default:
+		dropConnection(w)
+	}
+}
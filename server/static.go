@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// newStaticHandler serves regular files from dir under prefix. Missing
+// files and directories fall through to stealth mode so the directory
+// layout is never revealed.
+func newStaticHandler(prefix, dir string) http.Handler {
+	fileServer := http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, prefix))
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || info.IsDir() {
+			dropConnection(w)
+			return
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
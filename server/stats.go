@@ -0,0 +1,230 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ipStats is the connection history of a single client IP
+type ipStats struct {
+	IP        string    `json:"ip"`
+	Live      int       `json:"live"`
+	Total     uint64    `json:"total"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// ClockSkewMs is the clock skew of the last ping from this IP when it
+	// was above the threshold, pointing at hosts with broken NTP
+	ClockSkewMs int64 `json:"clock_skew_ms,omitempty"`
+}
+
+// connectionStats tracks live and historical connection counts per IP.
+// The number of tracked IPs is bounded; idle entries are evicted oldest
+// first when the limit is reached.
+type connectionStats struct {
+	clock Clock
+
+	mu      sync.Mutex
+	maxIPs  int
+	entries map[string]*ipStats
+}
+
+func newConnectionStats(maxIPs int, clock Clock) *connectionStats {
+	return &connectionStats{clock: clock, maxIPs: maxIPs, entries: make(map[string]*ipStats)}
+}
+
+func (s *connectionStats) opened(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now().UTC()
+	entry, ok := s.entries[ip]
+	if !ok {
+		if len(s.entries) >= s.maxIPs {
+			s.evictOldest()
+		}
+		entry = &ipStats{IP: ip, FirstSeen: now}
+		s.entries[ip] = entry
+	}
+	entry.Live++
+	entry.Total++
+	entry.LastSeen = now
+}
+
+func (s *connectionStats) closed(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[ip]; ok && entry.Live > 0 {
+		entry.Live--
+	}
+}
+
+func (s *connectionStats) skewed(ip string, skew time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[ip]; ok {
+		entry.ClockSkewMs = skew.Milliseconds()
+	}
+}
+
+// live returns the number of open connections
+func (s *connectionStats) live() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for _, entry := range s.entries {
+		total += entry.Live
+	}
+	return total
+}
+
+// evictOldest drops the least recently seen IP without live connections
+func (s *connectionStats) evictOldest() {
+	var oldest *ipStats
+	for _, entry := range s.entries {
+		if entry.Live == 0 && (oldest == nil || entry.LastSeen.Before(oldest.LastSeen)) {
+			oldest = entry
+		}
+	}
+	if oldest != nil {
+		delete(s.entries, oldest.IP)
+	}
+}
+
+// top returns up to limit entries ordered by live connections, or by total
+// connections when byTotal is set
+func (s *connectionStats) top(limit int, byTotal bool) []ipStats {
+	s.mu.Lock()
+	result := make([]ipStats, 0, len(s.entries))
+	for _, entry := range s.entries {
+		result = append(result, *entry)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if byTotal && a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Live != b.Live {
+			return a.Live > b.Live
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.IP < b.IP
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// clientStatsMaxValues bounds the distinct header values tracked per
+// endpoint; further values are counted as clientStatsOther
+const clientStatsMaxValues = 1000
+
+const (
+	clientStatsNone  = "(none)"
+	clientStatsOther = "(other)"
+)
+
+// headerCount is a header value and the number of requests carrying it
+type headerCount struct {
+	Value string `json:"value"`
+	Count uint64 `json:"count"`
+}
+
+// endpointClients is the client breakdown of a single endpoint
+type endpointClients struct {
+	Requests   uint64        `json:"requests"`
+	UserAgents []headerCount `json:"user_agents"`
+	Origins    []headerCount `json:"origins"`
+}
+
+type headerCounts struct {
+	requests   uint64
+	userAgents map[string]uint64
+	origins    map[string]uint64
+}
+
+// clientStats counts the User-Agent and Origin headers of requests per
+// endpoint, showing which client implementations and embedding sites
+// generate the traffic
+type clientStats struct {
+	mu        sync.Mutex
+	endpoints map[string]*headerCounts
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{endpoints: make(map[string]*headerCounts)}
+}
+
+func (s *clientStats) record(endpoint, userAgent, origin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts, ok := s.endpoints[endpoint]
+	if !ok {
+		counts = &headerCounts{
+			userAgents: make(map[string]uint64),
+			origins:    make(map[string]uint64),
+		}
+		s.endpoints[endpoint] = counts
+	}
+	counts.requests++
+	countHeader(counts.userAgents, userAgent)
+	countHeader(counts.origins, origin)
+}
+
+func countHeader(counts map[string]uint64, value string) {
+	if value == "" {
+		value = clientStatsNone
+	}
+	if _, ok := counts[value]; !ok && len(counts) >= clientStatsMaxValues {
+		value = clientStatsOther
+	}
+	counts[value]++
+}
+
+// breakdown returns the top limit header values of every endpoint, or only
+// of endpoint when it is not empty
+func (s *clientStats) breakdown(endpoint string, limit int) map[string]endpointClients {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]endpointClients)
+	for name, counts := range s.endpoints {
+		if endpoint != "" && name != endpoint {
+			continue
+		}
+		result[name] = endpointClients{
+			Requests:   counts.requests,
+			UserAgents: topHeaders(counts.userAgents, limit),
+			Origins:    topHeaders(counts.origins, limit),
+		}
+	}
+	return result
+}
+
+func topHeaders(counts map[string]uint64, limit int) []headerCount {
+	result := make([]headerCount, 0, len(counts))
+	for value, count := range counts {
+		result = append(result, headerCount{Value: value, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxTagLength bounds tags attached with /ws?tag=
+	maxTagLength = 64
+
+	tagNone  = "(none)"
+	tagOther = "(other)"
+)
+
+// connectionTag returns the tag a client attached to its connection with
+// /ws?tag=checkout-monitor, or "" when it is missing or malformed. Tags
+// are limited to letters, digits, '.', '_' and '-' so they are safe in
+// logs and as metric labels.
+func connectionTag(r *http.Request) string {
+	tag := r.URL.Query().Get("tag")
+	if len(tag) > maxTagLength {
+		return ""
+	}
+	for _, c := range tag {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return ""
+		}
+	}
+	return tag
+}
+
+// tagCount is the traffic of a single tag
+type tagCount struct {
+	Tag         string            `json:"tag"`
+	Connections uint64            `json:"connections"`
+	Results     map[string]uint64 `json:"results"`
+}
+
+// tagStats counts connections and their results per tag. At most maxTags
+// distinct tags are tracked, later ones are counted as tagOther, which
+// keeps the cardinality bounded when tags become metric labels. Only a
+// correctly signed ping claims a slot for a new tag, so junk tags from
+// failed exchanges can't crowd out real ones.
+type tagStats struct {
+	mu      sync.Mutex
+	maxTags int
+	entries map[string]*tagCount
+}
+
+func newTagStats(maxTags int) *tagStats {
+	return &tagStats{maxTags: maxTags, entries: make(map[string]*tagCount)}
+}
+
+// label maps a tag to the bounded set of tracked tags
+func (t *tagStats) label(tag string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.labelLocked(tag, false)
+}
+
+// labelLocked maps tag to its label; an untracked tag gets a slot of its
+// own only when admit is set and one is free
+func (t *tagStats) labelLocked(tag string, admit bool) string {
+	if tag == "" {
+		return tagNone
+	}
+	if _, ok := t.entries[tag]; ok || (admit && len(t.entries) < t.maxTags) {
+		return tag
+	}
+	return tagOther
+}
+
+// validResult reports whether result comes from a correctly signed ping
+func validResult(result string) bool {
+	return result == "ok" || result == "time" || result == "probe"
+}
+
+func (t *tagStats) record(tag, result string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	label := t.labelLocked(tag, validResult(result))
+	entry, ok := t.entries[label]
+	if !ok {
+		entry = &tagCount{Tag: label, Results: make(map[string]uint64)}
+		t.entries[label] = entry
+	}
+	entry.Connections++
+	entry.Results[result]++
+}
+
+// snapshot returns all tags ordered by connections
+func (t *tagStats) snapshot() []tagCount {
+	t.mu.Lock()
+	result := make([]tagCount, 0, len(t.entries))
+	for _, entry := range t.entries {
+		results := make(map[string]uint64, len(entry.Results))
+		for k, v := range entry.Results {
+			results[k] = v
+		}
+		result = append(result, tagCount{Tag: entry.Tag, Connections: entry.Connections, Results: results})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Connections != result[j].Connections {
+			return result[i].Connections > result[j].Connections
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	return result
+}
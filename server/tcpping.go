@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// tcpPingProtocol names plain TCP pings in logs, samples and the auth
+// failure log; the protocol is the line-based one of ALPNPingProtocol
+const tcpPingProtocol = "tcp"
+
+// ServeTCPPing answers line-based pings, as on ALPNPingProtocol but
+// without TLS, on connections accepted from ln until a shutdown starts.
+// Serve calls it for Config.TCPPingAddr; embedders may pass their own
+// listener. It returns nil once the shutdown closed ln.
+func (s *Server) ServeTCPPing(ln net.Listener) error {
+	stop := context.AfterFunc(s.drainCtx, func() { ln.Close() })
+	defer stop()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.drainCtx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Out of file descriptors and the like; back off as net/http does
+			slog.Warn("TCP ping accept failed", "error", err)
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+
+		s.activeConns.Add(1)
+		go func() {
+			defer s.activeConns.Done()
+			defer conn.Close()
+			defer s.reportPanic("tcp ping")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stopConn := context.AfterFunc(s.connCtx, func() {
+				cancel()
+				conn.Close()
+			})
+			defer stopConn()
+
+			s.serveLinePings(ctx, conn, &http.Request{
+				Method:     "PING",
+				URL:        &url.URL{Path: tcpPingProtocol},
+				Proto:      tcpPingProtocol,
+				Header:     http.Header{},
+				RemoteAddr: conn.RemoteAddr().String(),
+			})
+		}()
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TimeMessage answers a time request, over /api/time or as a "time"
+// message on /ws. Following NTP, a client that notes when it sent the
+// request (Origin, t1) and received the answer (t4) gets its clock offset
+// as ((Receive - t1) + (Transmit - t4)) / 2 and the round-trip delay as
+// (t4 - t1) - ProcessingUs, so the time spent in the server doesn't skew
+// either.
+type TimeMessage struct {
+	// Type is "time" on WebSocket connections
+	Type string `json:"type,omitempty"`
+	// Origin echoes the client's timestamp
+	Origin string `json:"origin,omitempty"`
+	// Receive is when the request arrived and Transmit when the answer
+	// was sent
+	Receive  string `json:"receive_time"`
+	Transmit string `json:"transmit_time"`
+	// UnixUs is Transmit in microseconds since the epoch, for clients
+	// that can't parse RFC 3339
+	UnixUs int64 `json:"unix_us"`
+	// ProcessingUs is the time between Receive and Transmit
+	ProcessingUs int64 `json:"processing_us"`
+}
+
+// newTimeMessage stamps an answer to a request received at received.
+// Transmit is taken last so it is as close to the write as possible.
+func newTimeMessage(origin string, received time.Time) TimeMessage {
+	now := time.Now()
+	return TimeMessage{
+		Origin:       origin,
+		Receive:      received.UTC().Format(time.RFC3339Nano),
+		Transmit:     now.UTC().Format(time.RFC3339Nano),
+		UnixUs:       now.UnixMicro(),
+		ProcessingUs: now.Sub(received).Microseconds(),
+	}
+}
+
+// sendTime answers a "time" message received at received
+func sendTime(conn replyWriter, pingMsg PingMessage, received time.Time) {
+	timeMsg := newTimeMessage(pingMsg.Timestamp, received)
+	timeMsg.Type = "time"
+	if jsonData, err := json.Marshal(timeMsg); err == nil {
+		conn.WriteMessage(websocket.TextMessage, jsonData)
+	}
+}
+
+// handleTime serves /api/time; the client's send time can be passed as
+// ?origin= and is echoed back
+func handleTime(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(newTimeMessage(r.URL.Query().Get("origin"), received))
+}
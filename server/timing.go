@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// histogram counts durations in fixed millisecond buckets
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sumMs  float64
+}
+
+// histogramBounds are the bucket upper bounds in milliseconds
+var histogramBounds = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// histogramBucket is the number of observations up to LeMs, cumulative
+type histogramBucket struct {
+	LeMs  float64 `json:"le_ms"`
+	Count uint64  `json:"count"`
+}
+
+type histogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	SumMs   float64           `json:"sum_ms"`
+	Buckets []histogramBucket `json:"buckets"`
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(histogramBounds))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	ms := float64(d.Microseconds()) / 1000
+	i := sort.SearchFloat64s(histogramBounds, ms)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sumMs += ms
+}
+
+func (h *histogram) snapshot() histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := histogramSnapshot{Count: h.count, SumMs: h.sumMs, Buckets: make([]histogramBucket, len(h.counts))}
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		snapshot.Buckets[i] = histogramBucket{LeMs: histogramBounds[i], Count: cumulative}
+	}
+	return snapshot
+}
+
+// stageTimings are the connection stage latencies, to tell slow crypto
+// from slow networks and lazy clients
+type stageTimings struct {
+	// tlsHandshake runs from the ClientHello to the server verifying the
+	// handshake
+	tlsHandshake *histogram
+	// upgrade runs from the connection being ready (accepted, or the TLS
+	// handshake done) to the WebSocket upgrade response being sent
+	upgrade *histogram
+	// firstMessage runs from the upgrade to the client's first message
+	firstMessage *histogram
+}
+
+func newStageTimings() *stageTimings {
+	return &stageTimings{
+		tlsHandshake: newHistogram(),
+		upgrade:      newHistogram(),
+		firstMessage: newHistogram(),
+	}
+}
+
+// connTiming carries the stage timestamps of one connection
+type connTiming struct {
+	mu    sync.Mutex
+	ready time.Time
+}
+
+func (t *connTiming) setReady(at time.Time) {
+	t.mu.Lock()
+	t.ready = at
+	t.mu.Unlock()
+}
+
+func (t *connTiming) readyAt() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ready
+}
+
+// timedConn attaches timing to an accepted connection
+type timedConn struct {
+	net.Conn
+	timing *connTiming
+}
+
+func newTimedConn(conn net.Conn) *timedConn {
+	return &timedConn{Conn: conn, timing: &connTiming{ready: time.Now()}}
+}
+
+type connTimingKey struct{}
+
+// withConnTiming is the server's ConnContext, making a connection's timing
+// available to its requests
+func withConnTiming(ctx context.Context, c net.Conn) context.Context {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	if conn, ok := c.(*timedConn); ok {
+		return context.WithValue(ctx, connTimingKey{}, conn.timing)
+	}
+	return ctx
+}
+
+func connTimingFrom(ctx context.Context) *connTiming {
+	timing, _ := ctx.Value(connTimingKey{}).(*connTiming)
+	return timing
+}
+
+// timeHandshakes makes config record TLS handshake durations. Every
+// handshake gets its own config so the verification callback knows which
+// connection it belongs to.
+func (t *stageTimings) timeHandshakes(config *tls.Config) {
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		conn, ok := hello.Conn.(*timedConn)
+		if !ok {
+			return nil, nil
+		}
+
+		start := time.Now()
+		perConn := config.Clone()
+		perConn.GetConfigForClient = nil
+		perConn.VerifyConnection = func(tls.ConnectionState) error {
+			now := time.Now()
+			t.tlsHandshake.observe(now.Sub(start))
+			conn.timing.setReady(now)
+			return nil
+		}
+		return perConn, nil
+	}
+}
@@ -0,0 +1,316 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// watchdogTick is how often the watchdog wakes up; a wake-up later
+	// than watchdogStallThreshold means the whole process was stalled
+	watchdogTick           = time.Second
+	watchdogStallThreshold = 2 * time.Second
+	// maxStackDump bounds the goroutine dump kept for the admin API
+	maxStackDump = 8 << 20
+	// maxLoggedStacks bounds the part of a dump written to the log when
+	// the dump couldn't be saved to a file
+	maxLoggedStacks = 64 << 10
+	// keptStackDumps is how many dump files are left in the temporary
+	// directory; older ones are removed as new ones are saved
+	keptStackDumps = 5
+)
+
+// watchdog notices work that stopped making progress: requests and ping
+// exchanges running longer than timeout, and stalls of the whole process.
+// Either gets the goroutine stacks logged and kept for /admin/watchdog,
+// since a wedged ping server otherwise looks like a healthy idle one.
+type watchdog struct {
+	timeout time.Duration
+	health  *healthRegistry
+
+	mu       sync.Mutex
+	nextID   uint64
+	inFlight map[uint64]*watchedTask
+	stalls   int64
+	stuck    int64
+	lastDump *stackDump
+}
+
+// watchedTask is one request or exchange in flight
+type watchedTask struct {
+	watchdog *watchdog
+	id       uint64
+	name     string
+	clientIP string
+	started  time.Time
+	reported bool
+}
+
+// stackDump is the goroutine dump taken when the watchdog last fired
+type stackDump struct {
+	Time       time.Time `json:"time"`
+	Reason     string    `json:"reason"`
+	Goroutines int       `json:"goroutines"`
+	// File is where the dump was saved for the log to point to, if it
+	// could be
+	File   string `json:"file,omitempty"`
+	Stacks string `json:"stacks"`
+}
+
+// logAttrs describe d in a log line: the file it was saved to, or its
+// beginning when it couldn't be saved, as a full dump can run to megabytes
+func (d *stackDump) logAttrs() []any {
+	attrs := []any{"goroutines", d.Goroutines}
+	if d.File != "" {
+		return append(attrs, "file", d.File)
+	}
+	stacks := d.Stacks
+	if len(stacks) > maxLoggedStacks {
+		stacks = stacks[:maxLoggedStacks] + "\n... truncated"
+	}
+	return append(attrs, "stacks", stacks)
+}
+
+// newWatchdog returns nil, watching nothing, when timeout is not positive
+func newWatchdog(timeout time.Duration, health *healthRegistry) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &watchdog{
+		timeout:  timeout,
+		health:   health,
+		inFlight: make(map[uint64]*watchedTask),
+	}
+}
+
+// begin starts watching a task; the caller must call done when it
+// finishes. It returns nil when w is nil.
+func (w *watchdog) begin(name, clientIP string) *watchedTask {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.nextID++
+	task := &watchedTask{watchdog: w, id: w.nextID, name: name, clientIP: clientIP, started: time.Now()}
+	w.inFlight[task.id] = task
+	return task
+}
+
+// done stops watching t; it may be called more than once
+func (t *watchedTask) done() {
+	if t == nil {
+		return
+	}
+	t.watchdog.mu.Lock()
+	defer t.watchdog.mu.Unlock()
+	delete(t.watchdog.inFlight, t.id)
+}
+
+type watchedTaskKey struct{}
+
+// watchedTaskFrom returns the task watching the request of ctx, or nil.
+// Long-lived sessions call done on it once the request part is over.
+func watchedTaskFrom(ctx context.Context) *watchedTask {
+	task, _ := ctx.Value(watchedTaskKey{}).(*watchedTask)
+	return task
+}
+
+// run checks on the tasks in flight and the process itself until ctx ends
+func (w *watchdog) run(ctx context.Context) {
+	ticker := time.NewTicker(watchdogTick)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if lag := now.Sub(last) - watchdogTick; lag > watchdogStallThreshold {
+				w.stalled(lag)
+			}
+			last = now
+			w.check(now)
+		}
+	}
+}
+
+// stalled reports a wake-up that came lag too late: the runtime or the
+// host didn't schedule the server for that long
+func (w *watchdog) stalled(lag time.Duration) {
+	w.mu.Lock()
+	w.stalls++
+	w.mu.Unlock()
+	dump := w.dump(fmt.Sprintf("process stalled for %s", lag.Round(time.Millisecond)))
+	slog.Warn("Watchdog: process stalled", append([]any{"lag", lag.Round(time.Millisecond)}, dump.logAttrs()...)...)
+}
+
+// check reports tasks that passed the timeout, once each, and keeps the
+// health problem while any of them is still running
+func (w *watchdog) check(now time.Time) {
+	w.mu.Lock()
+	var fresh []watchedTask
+	wedged := 0
+	for _, task := range w.inFlight {
+		if now.Sub(task.started) < w.timeout {
+			continue
+		}
+		wedged++
+		if !task.reported {
+			task.reported = true
+			w.stuck++
+			fresh = append(fresh, *task)
+		}
+	}
+	w.mu.Unlock()
+
+	if wedged == 0 {
+		w.health.clear("watchdog")
+		return
+	}
+	w.health.set("watchdog", HealthDegraded, fmt.Sprintf("%d requests running longer than %s", wedged, w.timeout))
+	if len(fresh) == 0 {
+		return
+	}
+
+	dump := w.dump(fmt.Sprintf("%d requests running longer than %s", len(fresh), w.timeout))
+	for _, task := range fresh {
+		slog.Error("Watchdog: request stuck", "task", task.name, "client_ip", task.clientIP,
+			"running", now.Sub(task.started).Round(time.Second))
+	}
+	slog.Error("Watchdog: goroutine dump", dump.logAttrs()...)
+}
+
+// dump records the stacks of all goroutines as the last dump and saves
+// them to a file in the temporary directory, keeping the last
+// keptStackDumps files
+func (w *watchdog) dump(reason string) *stackDump {
+	dump := &stackDump{
+		Time:       time.Now().UTC(),
+		Reason:     reason,
+		Goroutines: runtime.NumGoroutine(),
+		Stacks:     string(allStacks()),
+	}
+	name := filepath.Join(os.TempDir(), "ming-mong-stacks-"+dump.Time.Format("20060102T150405.000Z")+".txt")
+	if err := os.WriteFile(name, []byte(dump.Stacks), 0600); err != nil {
+		slog.Warn("Failed to save goroutine dump", "file", name, "error", err)
+	} else {
+		dump.File = name
+		pruneStackDumps(os.TempDir(), keptStackDumps)
+	}
+	w.mu.Lock()
+	w.lastDump = dump
+	w.mu.Unlock()
+	return dump
+}
+
+// pruneStackDumps removes all but the newest keep dump files from dir. The
+// names sort by the time they were saved.
+func pruneStackDumps(dir string, keep int) {
+	names, err := filepath.Glob(filepath.Join(dir, "ming-mong-stacks-*.txt"))
+	if err != nil || len(names) <= keep {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-keep] {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove old goroutine dump", "file", name, "error", err)
+		}
+	}
+}
+
+// allStacks returns the stacks of all goroutines, truncated to
+// maxStackDump
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackDump {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// watchdogStatus is the body of GET /admin/watchdog
+type watchdogStatus struct {
+	Timeout  string        `json:"timeout"`
+	InFlight int           `json:"in_flight"`
+	Stuck    []stuckTask   `json:"stuck"`
+	Stalls   int64         `json:"stalls_total"`
+	Reported int64         `json:"stuck_total"`
+	LastDump *stackDump    `json:"last_dump,omitempty"`
+	Panics   []panicReport `json:"panics,omitempty"`
+}
+
+type stuckTask struct {
+	Task     string  `json:"task"`
+	ClientIP string  `json:"client_ip,omitempty"`
+	RunningS float64 `json:"running_s"`
+}
+
+func (w *watchdog) status(now time.Time) watchdogStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := watchdogStatus{
+		Timeout:  w.timeout.String(),
+		InFlight: len(w.inFlight),
+		Stuck:    []stuckTask{},
+		Stalls:   w.stalls,
+		Reported: w.stuck,
+		LastDump: w.lastDump,
+	}
+	for _, task := range w.inFlight {
+		if running := now.Sub(task.started); running >= w.timeout {
+			status.Stuck = append(status.Stuck, stuckTask{Task: task.name, ClientIP: task.clientIP, RunningS: running.Seconds()})
+		}
+	}
+	sort.Slice(status.Stuck, func(i, j int) bool { return status.Stuck[i].RunningS > status.Stuck[j].RunningS })
+	return status
+}
+
+// withWatchdog watches every request to handler and reports panics in it
+// to the crash reporter; the panic then continues to net/http as before
+func (s *Server) withWatchdog(handler http.Handler) http.Handler {
+	if s.watchdog == nil && s.crashes == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer s.reportPanic("handler " + r.URL.Path)
+		task := s.watchdog.begin(r.URL.Path, clientIPFromRequest(r))
+		defer task.done()
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), watchedTaskKey{}, task)))
+	})
+}
+
+// unwatched exempts handler from the watchdog: static files and feeds run
+// as long as the client takes to download them, which says nothing about
+// the server's progress
+func unwatched(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		watchedTaskFrom(r.Context()).done()
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminWatchdog reports requests the watchdog considers stuck, its
+// last goroutine dump and recent panics: GET /admin/watchdog
+func (s *Server) handleAdminWatchdog(w http.ResponseWriter, r *http.Request) {
+	if s.watchdog == nil {
+		dropConnection(w)
+		return
+	}
+	status := s.watchdog.status(time.Now())
+	if s.crashes != nil {
+		status.Panics = s.crashes.recent()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPruneStackDumps(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"ming-mong-stacks-20240115T103102.000Z.txt",
+		"ming-mong-stacks-20240115T103101.000Z.txt",
+		"ming-mong-stacks-20240116T000000.000Z.txt",
+		"ming-mong-stacks-20240114T235959.999Z.txt",
+		"unrelated.txt",
+	}
+	for _, name := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneStackDumps(dir, 2)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	sort.Strings(left)
+	want := []string{
+		"ming-mong-stacks-20240115T103102.000Z.txt",
+		"ming-mong-stacks-20240116T000000.000Z.txt",
+		"unrelated.txt",
+	}
+	if !reflect.DeepEqual(left, want) {
+		t.Fatalf("left %v, want %v", left, want)
+	}
+}
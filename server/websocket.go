@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type PingMessage struct {
+	Type      string `json:"type"`
+	Signature string `json:"signature"`
+	Timestamp string `json:"timestamp"`
+	Whoami    bool   `json:"whoami,omitempty"`
+	// Proxies asks for the intermediaries seen on the request
+	Proxies bool  `json:"proxies,omitempty"`
+	Sizes   []int `json:"sizes,omitempty"`
+	// KeyID selects a named signing secret, see Config.SigningKeys
+	KeyID string `json:"key_id,omitempty"`
+	// ClientID selects the client's own secret, see Config.ClientKeys
+	ClientID string `json:"client_id,omitempty"`
+	// Nonce is a random client value covered by the signature; the server
+	// rejects it when seen again while the signature is valid
+	Nonce string `json:"nonce,omitempty"`
+}
+
+type PongMessage struct {
+	Type       string           `json:"type"`
+	Status     string           `json:"status,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Timestamp  string           `json:"timestamp"`
+	ServerTime string           `json:"server_time,omitempty"`
+	Observed   *ObservedAddress `json:"observed,omitempty"`
+	Proxy      *ProxyInfo       `json:"proxy,omitempty"`
+	// Reasons lists the failing components when Status is "degraded"
+	Reasons []string `json:"reasons,omitempty"`
+	// ClockSkewMs is set when the client clock is off by more than the
+	// configured threshold; positive means the client is ahead
+	ClockSkewMs int64 `json:"clock_skew_ms,omitempty"`
+}
+
+// parsePing decodes a client message
+func parsePing(data []byte) (PingMessage, error) {
+	var pingMsg PingMessage
+	if err := json.Unmarshal(data, &pingMsg); err != nil {
+		return pingMsg, fmt.Errorf("%w: %v", ErrInvalidFormat, err)
+	}
+	return pingMsg, nil
+}
+
+// validatePing checks the message type, signature and site policy
+func (s *Server) validatePing(ctx context.Context, r *http.Request, clientIP, tag string, pingMsg PingMessage) (err error) {
+	defer func() { s.recordAuthFailure(r, err) }()
+
+	// Check message type
+	isProbe := s.config.Probe && pingMsg.Type == "probe"
+	isTime := s.config.TimeService && pingMsg.Type == "time"
+	if pingMsg.Type != "ping" && !isProbe && !isTime {
+		return fmt.Errorf("%w: %q", ErrInvalidType, pingMsg.Type)
+	}
+
+	// Validate signature
+	if len(pingMsg.Nonce) > maxNonceLength {
+		return fmt.Errorf("%w: nonce longer than %d characters", ErrInvalidFormat, maxNonceLength)
+	}
+	verifier := s.signatures.Load()
+	now := s.clock.Now()
+	if pingMsg.ClientID != "" {
+		// Outcomes are attributed to the client ID, valid or not
+		_, known := verifier.clients[pingMsg.ClientID]
+		defer func() { s.keyStats.record(pingMsg.ClientID, known, clientIP, err, now) }()
+	}
+	if !verifier.valid(pingMsg.KeyID, pingMsg.ClientID, pingMsg.Nonce, pingMsg.Signature, now) {
+		if pingMsg.ClientID != "" {
+			return fmt.Errorf("%w: %s for client %q", ErrInvalidSignature, pingMsg.Signature, pingMsg.ClientID)
+		}
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, pingMsg.Signature)
+	}
+
+	// Keys and clients may be limited to certain hours
+	if !verifier.withinAccessWindow(pingMsg.KeyID, pingMsg.ClientID, now) {
+		id := pingMsg.KeyID
+		if id == "" {
+			id = pingMsg.ClientID
+		}
+		return fmt.Errorf("%w: %q", ErrOutsideWindow, id)
+	}
+
+	// A captured ping can't be replayed while its signature is valid
+	if pingMsg.Nonce == "" && s.config.NonceRequired {
+		return ErrNonceRequired
+	}
+	if pingMsg.Nonce != "" {
+		if err := s.nonces.record(pingMsg.Nonce, now, verifier.window()); err != nil {
+			return err
+		}
+	}
+
+	// Site policy may still reject a correctly signed ping
+	if s.authHook != nil && !s.authHook.allow(ctx, authRequest{
+		IP:        clientIP,
+		Client:    r.UserAgent(),
+		Signature: pingMsg.Signature,
+		KeyID:     pingMsg.KeyID,
+		Timestamp: pingMsg.Timestamp,
+		Type:      pingMsg.Type,
+		Tag:       tag,
+	}) {
+		return ErrDenied
+	}
+
+	if isProbe && !validProbeSizes(pingMsg.Sizes, s.config.ProbeMaxSize) {
+		return fmt.Errorf("%w: %v", ErrInvalidProbe, pingMsg.Sizes)
+	}
+	return nil
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// The connection context ends with the handler or when shutdown stops
+	// waiting for the connection
+	s.activeConns.Add(1)
+	defer s.activeConns.Done()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stopOnClose := context.AfterFunc(s.connCtx, cancel)
+	defer stopOnClose()
+
+	// Log connection attempt
+	clientIP := clientIPFromRequest(r)
+	tag := connectionTag(r)
+
+	slog.Debug("WebSocket connection", "client_ip", clientIP, "tag", tag)
+	s.stats.opened(clientIP)
+	defer s.stats.closed(clientIP)
+
+	// Record the outcome of the exchange for the log and analytics sinks,
+	// once it is known or when the handler returns
+	start := time.Now()
+	startedAt := s.clock.Now()
+	result := "read_error"
+	var failure error
+	var finished time.Time
+	reported := false
+	report := func() {
+		if reported {
+			return
+		}
+		reported = true
+		if finished.IsZero() {
+			finished = time.Now()
+		}
+		logExchange(r, clientIP, tag, result, finished.Sub(start), failure)
+		s.tags.record(tag, result)
+		s.recordSample(PingSample{
+			Client:    r.UserAgent(),
+			IP:        clientIP,
+			LatencyMs: float64(finished.Sub(start).Microseconds()) / 1000,
+			Result:    result,
+			Timestamp: startedAt,
+			Tag:       tag,
+		})
+	}
+	defer report()
+
+	// Upgrade to WebSocket
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		result = "upgrade_failed"
+		failure = err
+		return
+	}
+	defer conn.Close()
+
+	upgraded := time.Now()
+	if timing := connTimingFrom(r.Context()); timing != nil {
+		s.timings.upgrade.observe(upgraded.Sub(timing.readyAt()))
+	}
+
+	// Shutdown and the handler returning both end the connection
+	stop := s.closeOnCancel(ctx, conn)
+	defer stop()
+
+	// Clients over their connection rate are told so before reading
+	if err := s.checkRateLimit(ctx, s.limitIP(r)); err != nil {
+		result = errorCode(err)
+		failure = err
+		s.sendError(conn, err)
+		return
+	}
+
+	// Set read deadline (5 second timeout)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Read, parse and validate the message
+	messageBytes, err := readLimitedMessage(conn, s.config.MaxMessageSize)
+	if err != nil && !errors.Is(err, ErrOversizedMessage) {
+		failure = err
+		return
+	}
+	received := time.Now()
+	s.timings.firstMessage.observe(received.Sub(upgraded))
+
+	// Shadow traffic gets the raw message once the outcome is known;
+	// probes are interactive and not mirrored
+	if s.mirror != nil && err == nil {
+		defer func() {
+			if result != "probe" {
+				s.mirror.submit(mirrorRequest{
+					message:   messageBytes,
+					result:    result,
+					clientIP:  clientIP,
+					userAgent: r.UserAgent(),
+				})
+			}
+		}()
+	}
+
+	// Later messages (probe acknowledgements) are capped as well
+	conn.SetReadLimit(s.config.MaxMessageSize)
+
+	// Sealed messages get sealed replies; the mirror still gets them
+	// sealed
+	plaintext, replies := messageBytes, replyWriter(conn)
+	if err == nil && s.sealer != nil {
+		plaintext, replies, err = s.sealer.open(conn, messageBytes)
+	}
+
+	var pingMsg PingMessage
+	if err == nil {
+		pingMsg, err = parsePing(plaintext)
+	}
+	if err == nil {
+		err = s.validatePing(ctx, r, clientIP, tag, pingMsg)
+	}
+	// Probes exchange frames of exact sizes, which sealing would change
+	if _, sealed := replies.(*sealedReplies); err == nil && sealed && pingMsg.Type == "probe" {
+		err = fmt.Errorf("%w: sealed %q", ErrInvalidType, pingMsg.Type)
+	}
+	if err != nil {
+		result = errorCode(err)
+		failure = err
+		s.sendError(replies, err)
+		return
+	}
+
+	// A priority slot claimed with another key or client's ID is given up
+	if !admissionSlotFrom(r.Context()).confirm(pingMsg.KeyID, pingMsg.ClientID) {
+		result = "overloaded"
+		failure = fmt.Errorf("signed with neither key_id %q nor client_id %q claimed for admission", r.URL.Query().Get("key_id"), r.URL.Query().Get("client_id"))
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server overloaded"),
+			time.Now().Add(keepAliveWriteTimeout))
+		return
+	}
+
+	// Frame-size probing session
+	if pingMsg.Type == "probe" {
+		watchedTaskFrom(r.Context()).done()
+		result = "probe"
+		probeResult := runProbe(ctx, conn, pingMsg.Sizes, clientIP, s.clock)
+		if jsonData, err := json.Marshal(probeResult); err == nil {
+			conn.SetWriteDeadline(time.Now().Add(probeStepTimeout))
+			conn.WriteMessage(websocket.TextMessage, jsonData)
+		}
+		return
+	}
+
+	if pingMsg.Type == "time" {
+		// Time requests get the server clock instead of a pong
+		result = "time"
+		sendTime(replies, pingMsg, received)
+	} else {
+		// Valid signature - send pong
+		result = "ok"
+		s.sendPong(replies, r, clientIP, pingMsg)
+	}
+	finished = time.Now()
+	report()
+
+	// With keepalive the connection stays open for further pings, as a
+	// persistent session that outranks anonymous traffic under overload
+	if s.config.KeepAliveInterval > 0 {
+		admissionSlotFrom(r.Context()).promote()
+		watchedTaskFrom(r.Context()).done()
+		s.keepAlive(ctx, conn, r, clientIP, tag)
+	}
+}
+
+// sendPong answers a valid ping
+func (s *Server) sendPong(conn replyWriter, r *http.Request, clientIP string, pingMsg PingMessage) {
+	now := s.clock.Now().UTC()
+	pongMsg := PongMessage{
+		Type:       "pong",
+		Status:     "ok",
+		Timestamp:  now.Format(time.RFC3339Nano),
+		ServerTime: now.Format(time.RFC3339Nano),
+	}
+	// The ping got through either way; these tell the client whether the
+	// server itself is fully there
+	if s.maintenanceActive(now) {
+		pongMsg.Status = "maintenance"
+	} else if reasons := s.degradedReasons(); len(reasons) > 0 {
+		pongMsg.Status = "degraded"
+		pongMsg.Reasons = reasons
+	}
+	if pingMsg.Whoami {
+		pongMsg.Observed = observedAddress(r)
+	}
+	if pingMsg.Proxies {
+		pongMsg.Proxy = proxyInfo(r)
+	}
+	skew, skewed := clockSkew(pingMsg.Timestamp, now, s.config.ClockSkewThreshold)
+	if skewed {
+		slog.Info("Clock skew", "client_ip", clientIP, "skew_ms", skew.Milliseconds())
+		pongMsg.ClockSkewMs = skew.Milliseconds()
+	}
+	s.stats.skewed(clientIP, skew)
+
+	if jsonData, err := json.Marshal(pongMsg); err == nil {
+		conn.WriteMessage(websocket.TextMessage, jsonData)
+	}
+}
+
+// logExchange logs the outcome of a WebSocket exchange
+func logExchange(r *http.Request, clientIP, tag, result string, duration time.Duration, err error) {
+	attrs := []any{
+		"client_ip", clientIP,
+		"endpoint", r.URL.Path,
+		"tag", tag,
+		"outcome", result,
+		"duration_ms", float64(duration.Microseconds()) / 1000,
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	slog.Info("WebSocket exchange", attrs...)
+}
+
+// dropConnection closes the underlying connection without any response,
+// making the server look offline to scanners
+func dropConnection(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		conn, _, err := hijacker.Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}
+}
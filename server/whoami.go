@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// ObservedAddress is how the server sees the caller, letting clients behind
+// NAT learn their public address
+type ObservedAddress struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`
+	TLS      string `json:"tls,omitempty"`
+}
+
+// observedAddress reports the caller's address; the port is only known
+// when the client connects directly rather than through a proxy
+func observedAddress(r *http.Request) *ObservedAddress {
+	observed := &ObservedAddress{
+		IP:       clientIPFromRequest(r),
+		Protocol: r.Proto,
+	}
+
+	if r.Header.Get("X-Real-IP") == "" && r.Header.Get("X-Forwarded-For") == "" {
+		if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			observed.Port, _ = strconv.Atoi(port)
+		}
+	}
+
+	if r.TLS != nil {
+		observed.TLS = tls.VersionName(r.TLS.Version)
+	}
+	return observed
+}
+
+func handleWhoami(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(observedAddress(r))
+}